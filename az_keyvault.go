@@ -4,8 +4,12 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"log"
+	"net"
+	"net/url"
+	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
@@ -19,34 +23,120 @@ type AzKVClients struct {
 	secrets      *azsecrets.Client
 	keys         *azkeys.Client
 	certificates *azcertificates.Client
+
+	throttle *throttle
+	breaker  *circuitBreaker
+	// hsm is set for a Managed HSM, which only has a key client.
+	hsm bool
+}
+
+// ConnectOptions configures how the Key Vault clients talk to Azure.
+type ConnectOptions struct {
+	// Retry configures the SDK retry policy, including how long a
+	// Retry-After header may delay a retry (MaxRetryDelay).
+	Retry policy.RetryOptions
+	// RequestsPerSecond limits the calls made by all clients together.
+	// Zero or less disables client-side rate limiting.
+	RequestsPerSecond float64
+	// Burst is the number of calls that may exceed RequestsPerSecond at once.
+	Burst int
+	// BreakerThreshold is the number of consecutive failed calls after which
+	// calls fail fast for BreakerCooldown. Zero or less disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// Credentials select how to authenticate.
+	Credentials credentialConfig
+	// Cloud is the Azure cloud to authenticate in.
+	Cloud cloudConfig
+	// Transport sends the requests of the credential and the clients; nil
+	// uses the SDK's default HTTP client.
+	Transport policy.Transporter
+	// ManagedHSM connects to a Managed HSM, which only stores keys.
+	ManagedHSM bool
+	// DisableChallengeResourceVerification allows vault URLs outside the
+	// Key Vault DNS suffix, like the emulator on localhost.
+	DisableChallengeResourceVerification bool
 }
 
-func ConnectToKeyVault(url string) *AzKVClients {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+// isLoopbackVault reports whether a vault URL points at this machine, which
+// only happens when using the emulator.
+func isLoopbackVault(vaultURL string) bool {
+	parsed, err := url.Parse(vaultURL)
 	if err != nil {
-		log.Fatalf("failed to obtain a credential: %v", err)
+		return false
+	}
+	host := parsed.Hostname()
+	if host == "localhost" {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
 
-	secretClient, err := azsecrets.NewClient(url, cred, nil)
+func (options *ConnectOptions) clientOptions(throttle *throttle, breaker *circuitBreaker) azcore.ClientOptions {
+	return azcore.ClientOptions{
+		Retry:            options.Retry,
+		PerCallPolicies:  []policy.Policy{breaker},
+		PerRetryPolicies: []policy.Policy{throttle},
+		Transport:        options.Transport,
+	}
+}
+
+func ConnectToKeyVault(vaultURL string, options ConnectOptions) *AzKVClients {
+	cred, err := newCredential(options.Credentials, options.Cloud, options.Transport)
 	if err != nil {
-		log.Fatalf("failed to create a secret client: %v", err)
+		log.Fatalf("failed to obtain a credential: %v", err)
+	}
+	scope := options.Cloud.scope()
+	if options.ManagedHSM {
+		// Managed HSM has an audience of its own, announced in its challenges
+		scope = managedHSMScope(vaultURL)
+	} else if len(options.Cloud.Audience) != 0 {
+		cred = &audienceCredential{credential: cred, scope: scope}
+	}
+	if isInteractive(options.Credentials.Type) {
+		if err := signIn(cred, scope); err != nil {
+			log.Fatalf("failed to sign in: %v", err)
+		}
 	}
 
-	keyClient, err := azkeys.NewClient(url, cred, nil)
+	throttle := newThrottle(options.RequestsPerSecond, options.Burst)
+	breaker := newCircuitBreaker(options.BreakerThreshold, options.BreakerCooldown)
+	clientOptions := options.clientOptions(throttle, breaker)
+
+	keyClient, err := azkeys.NewClient(vaultURL, cred, &azkeys.ClientOptions{
+		ClientOptions:                        clientOptions,
+		DisableChallengeResourceVerification: options.DisableChallengeResourceVerification,
+	})
 	if err != nil {
 		log.Fatalf("failed to create a key client: %v", err)
 	}
+	clients := &AzKVClients{
+		keys:     keyClient,
+		throttle: throttle,
+		breaker:  breaker,
+		hsm:      options.ManagedHSM,
+	}
+	if options.ManagedHSM {
+		return clients
+	}
 
-	certClient, err := azcertificates.NewClient(url, cred, nil)
+	clients.secrets, err = azsecrets.NewClient(vaultURL, cred, &azsecrets.ClientOptions{
+		ClientOptions:                        clientOptions,
+		DisableChallengeResourceVerification: options.DisableChallengeResourceVerification,
+	})
 	if err != nil {
-		log.Fatalf("failed to create a certificate client: %v", err)
+		log.Fatalf("failed to create a secret client: %v", err)
 	}
 
-	return &AzKVClients{
-		secrets:      secretClient,
-		keys:         keyClient,
-		certificates: certClient,
+	clients.certificates, err = azcertificates.NewClient(vaultURL, cred, &azcertificates.ClientOptions{
+		ClientOptions:                        clientOptions,
+		DisableChallengeResourceVerification: options.DisableChallengeResourceVerification,
+	})
+	if err != nil {
+		log.Fatalf("failed to create a certificate client: %v", err)
 	}
+	return clients
 }
 
 func ConvertEntry(typ string, data []byte) []byte {
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/pkg/errors"
+)
+
+// Backend is where the objects shown in the file system are stored. It is
+// implemented by AzKVClients for Azure Key Vault and by LocalBackend for
+// working without Azure. Objects use the Key Vault SDK models regardless of
+// where they come from, so views do not need to care.
+type Backend interface {
+	// List returns the properties of all objects of a kind.
+	List(ctx context.Context, kind objectKind) ([]objectProperties, error)
+
+	GetCertificate(ctx context.Context, name string) (azcertificates.Certificate, error)
+	GetKey(ctx context.Context, name string) (azkeys.KeyBundle, error)
+	GetSecret(ctx context.Context, name string) (azsecrets.Secret, error)
+
+	ImportCertificate(ctx context.Context, name string,
+		parameters azcertificates.ImportCertificateParameters) (azcertificates.Certificate, error)
+	ImportKey(ctx context.Context, name string, parameters azkeys.ImportKeyParameters) (azkeys.KeyBundle, error)
+	SetSecret(ctx context.Context, name string, parameters azsecrets.SetSecretParameters) (azsecrets.Secret, error)
+
+	// Delete removes an object. Certificates are removed along with their
+	// backing secret.
+	Delete(ctx context.Context, kind objectKind, name string) error
+}
+
+var (
+	_ Backend = (*AzKVClients)(nil)
+)
+
+func (kind objectKind) dirName() string {
+	switch kind {
+	case certificateKind:
+		return certificatesDirName
+	case keyKind:
+		return keysDirName
+	case secretKind:
+		return secretsDirName
+	default:
+		return ""
+	}
+}
+
+func modTimeOf(updated *time.Time, created *time.Time) time.Time {
+	if updated != nil {
+		return *updated
+	} else if created != nil {
+		return *created
+	}
+	return time.UnixMilli(0)
+}
+
+func (clients *AzKVClients) List(ctx context.Context, kind objectKind) ([]objectProperties, error) {
+	if err := clients.isHSMSupported(kind); err != nil {
+		return nil, err
+	}
+	var objects []objectProperties
+	switch kind {
+	case certificateKind:
+		pager := clients.certificates.NewListCertificatePropertiesPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not get next page for certificates")
+			}
+			for _, certificate := range page.Value {
+				props := objectProperties{name: certificate.ID.Name(), modTime: time.UnixMilli(0)}
+				if certificate.Attributes != nil {
+					props.modTime = modTimeOf(certificate.Attributes.Updated, certificate.Attributes.Created)
+				}
+				objects = append(objects, props)
+			}
+		}
+	case keyKind:
+		pager := clients.keys.NewListKeyPropertiesPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not get next page for keys")
+			}
+			for _, key := range page.Value {
+				props := objectProperties{name: key.KID.Name(), modTime: time.UnixMilli(0)}
+				if key.Attributes != nil {
+					props.modTime = modTimeOf(key.Attributes.Updated, key.Attributes.Created)
+				}
+				objects = append(objects, props)
+			}
+		}
+	case secretKind:
+		pager := clients.secrets.NewListSecretPropertiesPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not get next page for secrets")
+			}
+			for _, secret := range page.Value {
+				props := objectProperties{name: secret.ID.Name(), modTime: time.UnixMilli(0)}
+				if secret.Attributes != nil {
+					props.modTime = modTimeOf(secret.Attributes.Updated, secret.Attributes.Created)
+				}
+				if secret.ContentType != nil {
+					props.contentType = *secret.ContentType
+				}
+				objects = append(objects, props)
+			}
+		}
+	default:
+		return nil, errors.Errorf("unknown object kind %d", kind)
+	}
+	return objects, nil
+}
+
+func (clients *AzKVClients) GetCertificate(ctx context.Context, name string) (azcertificates.Certificate, error) {
+	if err := clients.isHSMSupported(certificateKind); err != nil {
+		return azcertificates.Certificate{}, err
+	}
+	response, err := clients.certificates.GetCertificate(ctx, name, "", nil)
+	if err != nil {
+		return azcertificates.Certificate{}, err
+	}
+	return response.Certificate, nil
+}
+
+func (clients *AzKVClients) GetKey(ctx context.Context, name string) (azkeys.KeyBundle, error) {
+	response, err := clients.keys.GetKey(ctx, name, "", nil)
+	if err != nil {
+		return azkeys.KeyBundle{}, err
+	}
+	return response.KeyBundle, nil
+}
+
+func (clients *AzKVClients) GetSecret(ctx context.Context, name string) (azsecrets.Secret, error) {
+	if err := clients.isHSMSupported(secretKind); err != nil {
+		return azsecrets.Secret{}, err
+	}
+	response, err := clients.secrets.GetSecret(ctx, name, "", nil)
+	if err != nil {
+		return azsecrets.Secret{}, err
+	}
+	return response.Secret, nil
+}
+
+func (clients *AzKVClients) ImportCertificate(ctx context.Context, name string,
+	parameters azcertificates.ImportCertificateParameters) (azcertificates.Certificate, error) {
+	if err := clients.isHSMSupported(certificateKind); err != nil {
+		return azcertificates.Certificate{}, err
+	}
+	response, err := clients.certificates.ImportCertificate(ctx, name, parameters, nil)
+	if err != nil {
+		return azcertificates.Certificate{}, err
+	}
+	return response.Certificate, nil
+}
+
+func (clients *AzKVClients) ImportKey(ctx context.Context, name string,
+	parameters azkeys.ImportKeyParameters) (azkeys.KeyBundle, error) {
+	response, err := clients.keys.ImportKey(ctx, name, parameters, nil)
+	if err != nil {
+		return azkeys.KeyBundle{}, err
+	}
+	return response.KeyBundle, nil
+}
+
+func (clients *AzKVClients) SetSecret(ctx context.Context, name string,
+	parameters azsecrets.SetSecretParameters) (azsecrets.Secret, error) {
+	if err := clients.isHSMSupported(secretKind); err != nil {
+		return azsecrets.Secret{}, err
+	}
+	response, err := clients.secrets.SetSecret(ctx, name, parameters, nil)
+	if err != nil {
+		return azsecrets.Secret{}, err
+	}
+	return response.Secret, nil
+}
+
+func (clients *AzKVClients) Delete(ctx context.Context, kind objectKind, name string) error {
+	if err := clients.isHSMSupported(kind); err != nil {
+		return err
+	}
+	var err error
+	switch kind {
+	case certificateKind:
+		_, err = clients.certificates.DeleteCertificate(ctx, name, nil)
+	case keyKind:
+		_, err = clients.keys.DeleteKey(ctx, name, nil)
+	case secretKind:
+		_, err = clients.secrets.DeleteSecret(ctx, name, nil)
+	default:
+		err = errors.Errorf("unknown object kind %d", kind)
+	}
+	return err
+}
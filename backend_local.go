@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/pkg/errors"
+)
+
+const localVaultURL = "https://localhost"
+
+const pemContentType = "application/x-pem-file"
+
+const localObjectExtension = ".json"
+
+// LocalBackend is a fake vault stored in a directory. Every object is a JSON
+// file in the Key Vault REST format, i.e. the same as the .response files,
+// at <dir>/<secrets|keys|certificates>/<name>.json. Files may be written by
+// hand; only the fields needed by the views have to be present.
+type LocalBackend struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+var (
+	_ Backend = (*LocalBackend)(nil)
+)
+
+// NewLocalBackend opens or creates a local fake vault in dir.
+func NewLocalBackend(dir string) (*LocalBackend, error) {
+	for _, kind := range []objectKind{certificateKind, keyKind, secretKind} {
+		if err := os.MkdirAll(filepath.Join(dir, kind.dirName()), 0700); err != nil {
+			return nil, errors.Wrap(err, "could not create local vault")
+		}
+	}
+	return &LocalBackend{dir: dir}, nil
+}
+
+func (backend *LocalBackend) path(kind objectKind, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", errors.Errorf("invalid %s name %q", kind, name)
+	}
+	return filepath.Join(backend.dir, kind.dirName(), name+localObjectExtension), nil
+}
+
+func (backend *LocalBackend) read(kind objectKind, name string, object any) error {
+	path, err := backend.path(kind, name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "could not read %s %s", kind, name)
+	}
+	if err := json.Unmarshal(data, object); err != nil {
+		return errors.Wrapf(err, "could not parse %s", path)
+	}
+	return nil
+}
+
+func (backend *LocalBackend) write(kind objectKind, name string, object any) error {
+	path, err := backend.path(kind, name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(object, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return errors.Wrapf(err, "could not write %s %s", kind, name)
+	}
+	return os.Rename(temp, path)
+}
+
+// localID builds an object identifier like Key Vault does, so that
+// ID.Name() and ID.Version() work on objects from the local vault.
+func localID(kind objectKind, name string, version string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", localVaultURL, kind.dirName(), name, version)
+}
+
+func newLocalVersion() string {
+	version := make([]byte, 16)
+	_, _ = rand.Read(version)
+	return hex.EncodeToString(version)
+}
+
+func (backend *LocalBackend) List(ctx context.Context, kind objectKind) ([]objectProperties, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	files, err := os.ReadDir(filepath.Join(backend.dir, kind.dirName()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not list %ss", kind)
+	}
+	var objects []objectProperties
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), localObjectExtension) {
+			continue
+		}
+		props := objectProperties{
+			name:    strings.TrimSuffix(file.Name(), localObjectExtension),
+			modTime: time.UnixMilli(0),
+		}
+		switch kind {
+		case certificateKind:
+			var certificate azcertificates.Certificate
+			if err := backend.read(kind, props.name, &certificate); err != nil {
+				return nil, err
+			}
+			if certificate.Attributes != nil {
+				props.modTime = modTimeOf(certificate.Attributes.Updated, certificate.Attributes.Created)
+			}
+		case keyKind:
+			var key azkeys.KeyBundle
+			if err := backend.read(kind, props.name, &key); err != nil {
+				return nil, err
+			}
+			if key.Attributes != nil {
+				props.modTime = modTimeOf(key.Attributes.Updated, key.Attributes.Created)
+			}
+		case secretKind:
+			var secret azsecrets.Secret
+			if err := backend.read(kind, props.name, &secret); err != nil {
+				return nil, err
+			}
+			if secret.Attributes != nil {
+				props.modTime = modTimeOf(secret.Attributes.Updated, secret.Attributes.Created)
+			}
+			if secret.ContentType != nil {
+				props.contentType = *secret.ContentType
+			}
+		}
+		objects = append(objects, props)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].name < objects[j].name
+	})
+	return objects, nil
+}
+
+func (backend *LocalBackend) GetCertificate(ctx context.Context, name string) (azcertificates.Certificate, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	var certificate azcertificates.Certificate
+	if err := backend.read(certificateKind, name, &certificate); err != nil {
+		return azcertificates.Certificate{}, err
+	}
+	if certificate.ID == nil {
+		id := azcertificates.ID(localID(certificateKind, name, ""))
+		certificate.ID = &id
+	}
+	return certificate, nil
+}
+
+func (backend *LocalBackend) GetKey(ctx context.Context, name string) (azkeys.KeyBundle, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	var key azkeys.KeyBundle
+	if err := backend.read(keyKind, name, &key); err != nil {
+		return azkeys.KeyBundle{}, err
+	}
+	if key.Key == nil {
+		key.Key = &azkeys.JSONWebKey{}
+	}
+	if key.Key.KID == nil {
+		id := azkeys.ID(localID(keyKind, name, ""))
+		key.Key.KID = &id
+	}
+	return key, nil
+}
+
+func (backend *LocalBackend) GetSecret(ctx context.Context, name string) (azsecrets.Secret, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	var secret azsecrets.Secret
+	if err := backend.read(secretKind, name, &secret); err != nil {
+		return azsecrets.Secret{}, err
+	}
+	if secret.ID == nil {
+		id := azsecrets.ID(localID(secretKind, name, ""))
+		secret.ID = &id
+	}
+	return secret, nil
+}
+
+func (backend *LocalBackend) ImportCertificate(ctx context.Context, name string,
+	parameters azcertificates.ImportCertificateParameters) (azcertificates.Certificate, error) {
+	if parameters.Base64EncodedCertificate == nil {
+		return azcertificates.Certificate{}, errors.New("certificate value is required")
+	}
+	password := ""
+	if parameters.Password != nil {
+		password = *parameters.Password
+	}
+	bundle, err := parseCertificateBundle([]byte(*parameters.Base64EncodedCertificate), password)
+	if err != nil {
+		return azcertificates.Certificate{}, errors.Wrap(err, "could not parse certificate")
+	}
+	leaf := bundle.leaf
+	contentType := bundle.contentType
+
+	// Like Key Vault, keep the certificate with its private key in a
+	// managed secret of the same name.
+	value := *parameters.Base64EncodedCertificate
+	if contentType == pemContentType {
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+			value = string(decoded)
+		}
+	}
+	managed := true
+	secret, err := backend.SetSecret(ctx, name, azsecrets.SetSecretParameters{
+		Value:       &value,
+		ContentType: &contentType,
+		Tags:        map[string]*string{},
+	})
+	if err != nil {
+		return azcertificates.Certificate{}, err
+	}
+	secret.Managed = &managed
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	if err := backend.write(secretKind, name, secret); err != nil {
+		return azcertificates.Certificate{}, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	version := secret.ID.Version()
+	id := azcertificates.ID(localID(certificateKind, name, version))
+	sid := azcertificates.ID(localID(secretKind, name, version))
+	kid := azcertificates.ID(localID(keyKind, name, version))
+	thumbprint := sha1.Sum(leaf.Raw)
+	enabled := true
+	certificate := azcertificates.Certificate{
+		Attributes: &azcertificates.CertificateAttributes{
+			Enabled:   &enabled,
+			Created:   &now,
+			Updated:   &now,
+			NotBefore: &leaf.NotBefore,
+			Expires:   &leaf.NotAfter,
+		},
+		CER:            leaf.Raw,
+		ContentType:    &contentType,
+		Tags:           parameters.Tags,
+		ID:             &id,
+		KID:            &kid,
+		SID:            &sid,
+		Policy:         parameters.CertificatePolicy,
+		X509Thumbprint: thumbprint[:],
+	}
+	if err := backend.write(certificateKind, name, certificate); err != nil {
+		return azcertificates.Certificate{}, err
+	}
+	return certificate, nil
+}
+
+func (backend *LocalBackend) ImportKey(ctx context.Context, name string,
+	parameters azkeys.ImportKeyParameters) (azkeys.KeyBundle, error) {
+	if parameters.Key == nil {
+		return azkeys.KeyBundle{}, errors.New("key is required")
+	}
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	enabled := true
+	jwk := *parameters.Key
+	kid := azkeys.ID(localID(keyKind, name, newLocalVersion()))
+	jwk.KID = &kid
+	key := azkeys.KeyBundle{
+		Attributes: &azkeys.KeyAttributes{
+			Enabled: &enabled,
+			Created: &now,
+			Updated: &now,
+		},
+		Key:           &jwk,
+		ReleasePolicy: parameters.ReleasePolicy,
+		Tags:          parameters.Tags,
+	}
+	if parameters.KeyAttributes != nil {
+		key.Attributes.Enabled = parameters.KeyAttributes.Enabled
+		key.Attributes.Expires = parameters.KeyAttributes.Expires
+		key.Attributes.NotBefore = parameters.KeyAttributes.NotBefore
+	}
+	if err := backend.write(keyKind, name, key); err != nil {
+		return azkeys.KeyBundle{}, err
+	}
+	return key, nil
+}
+
+func (backend *LocalBackend) SetSecret(ctx context.Context, name string,
+	parameters azsecrets.SetSecretParameters) (azsecrets.Secret, error) {
+	if parameters.Value == nil {
+		return azsecrets.Secret{}, errors.New("secret value is required")
+	}
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	enabled := true
+	id := azsecrets.ID(localID(secretKind, name, newLocalVersion()))
+	secret := azsecrets.Secret{
+		Attributes: &azsecrets.SecretAttributes{
+			Enabled: &enabled,
+			Created: &now,
+			Updated: &now,
+		},
+		ContentType: parameters.ContentType,
+		ID:          &id,
+		Tags:        parameters.Tags,
+		Value:       parameters.Value,
+	}
+	if parameters.SecretAttributes != nil {
+		secret.Attributes.Enabled = parameters.SecretAttributes.Enabled
+		secret.Attributes.Expires = parameters.SecretAttributes.Expires
+		secret.Attributes.NotBefore = parameters.SecretAttributes.NotBefore
+	}
+	if err := backend.write(secretKind, name, secret); err != nil {
+		return azsecrets.Secret{}, err
+	}
+	return secret, nil
+}
+
+func (backend *LocalBackend) Delete(ctx context.Context, kind objectKind, name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	path, err := backend.path(kind, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return errors.Wrapf(err, "could not delete %s %s", kind, name)
+	}
+	if kind == certificateKind {
+		secretPath, _ := backend.path(secretKind, name)
+		if err := os.Remove(secretPath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not delete secret of certificate %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSignedPEM creates a certificate and its private key as PEM.
+func selfSignedPEM(t *testing.T, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return append(
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+func newTestRoot(backend Backend) *listingEntry {
+	return newRootEntry(backend)
+}
+
+func Test_LocalBackend(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewLocalBackend(t.TempDir())
+	require.NoError(t, err)
+
+	value := "hunter2"
+	_, err = backend.SetSecret(ctx, "password", azsecrets.SetSecretParameters{Value: &value})
+	require.NoError(t, err)
+
+	certificatePEM := string(selfSignedPEM(t, "example.com"))
+	certificate, err := backend.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &certificatePEM,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "web", certificate.ID.Name())
+
+	secrets, err := backend.List(ctx, secretKind)
+	require.NoError(t, err)
+	assert.Len(t, secrets, 2, "importing a certificate creates its backing secret")
+	assert.Equal(t, "password", secrets[0].name)
+	assert.Equal(t, pemContentType, secrets[1].contentType)
+
+	root := newTestRoot(backend)
+	secretsDir, err := root.Find(secretsDirName, ctx)
+	require.NoError(t, err)
+	file, err := secretsDir.Find("password", ctx)
+	require.NoError(t, err)
+	require.NotNil(t, file)
+	data, err := file.Download(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", string(data))
+
+	certificatesDir, err := root.Find(certificatesDirName, ctx)
+	require.NoError(t, err)
+	file, err = certificatesDir.Find("web.pem", ctx)
+	require.NoError(t, err)
+	data, err = file.Download(ctx)
+	require.NoError(t, err)
+	block, _ := pem.Decode(data)
+	assert.Equal(t, certificate.CER, block.Bytes)
+
+	require.NoError(t, backend.Delete(ctx, certificateKind, "web"))
+	_, err = backend.GetSecret(ctx, "web")
+	assert.Equal(t, syscall.ENOENT, errnoFor(err))
+	_, err = backend.GetCertificate(ctx, "web")
+	assert.Equal(t, syscall.ENOENT, errnoFor(err))
+}
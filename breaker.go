@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/pkg/errors"
+)
+
+var errCircuitOpen = errors.New("Key Vault circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (state breakerState) String() string {
+	switch state {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker is a pipeline policy that stops sending requests to Key
+// Vault after threshold consecutive failures. While open, requests fail
+// immediately with errCircuitOpen. Once cooldown has passed, a single
+// request is let through to probe whether Key Vault has recovered.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex    sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	now func() time.Time
+}
+
+var (
+	_ policy.Policy = (*circuitBreaker)(nil)
+)
+
+// newCircuitBreaker creates a circuit breaker. A threshold of zero or less
+// disables it.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	log.Println("Key Vault circuit breaker:", b.state, "->", state)
+	b.state = state
+}
+
+// allow reports whether a request may be sent now.
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Before(b.openedAt.Add(b.cooldown)) {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		// Only one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a request.
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+// abandon releases a probe whose outcome is unknown, e.g. because the
+// caller gave up on it.
+func (b *circuitBreaker) abandon() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// isBreakerFailure decides whether the outcome of a request indicates that
+// Key Vault is unavailable. Client errors such as 404 or 403 do not count.
+// Cancelled requests are handled separately by Do.
+func isBreakerFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func (b *circuitBreaker) Do(req *policy.Request) (*http.Response, error) {
+	if !b.allow() {
+		return nil, errCircuitOpen
+	}
+	resp, err := req.Next()
+	if errors.Is(err, context.Canceled) {
+		b.abandon()
+	} else {
+		b.record(isBreakerFailure(resp, err))
+	}
+	return resp, err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	assert.True(t, breaker.allow())
+	breaker.record(true)
+	assert.True(t, breaker.allow())
+	breaker.record(true)
+	assert.Equal(t, breakerOpen, breaker.state)
+	assert.False(t, breaker.allow(), "open breaker must fail fast")
+
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.allow(), "probe after cooldown")
+	assert.Equal(t, breakerHalfOpen, breaker.state)
+	assert.False(t, breaker.allow(), "only one probe at a time")
+	breaker.record(true)
+	assert.Equal(t, breakerOpen, breaker.state, "failed probe reopens")
+
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.allow())
+	breaker.record(false)
+	assert.Equal(t, breakerClosed, breaker.state)
+	assert.True(t, breaker.allow())
+}
+
+func Test_circuitBreakerDisabled(t *testing.T) {
+	breaker := newCircuitBreaker(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		breaker.record(true)
+		assert.True(t, breaker.allow())
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// certificateBundle is a certificate together with its private key and
+// issuers, as stored in the secret backing a Key Vault certificate.
+type certificateBundle struct {
+	key  crypto.PrivateKey
+	leaf *x509.Certificate
+	// chain holds the issuers of leaf in the order they were stored,
+	// usually intermediates first.
+	chain       []*x509.Certificate
+	contentType string
+}
+
+// parseCertificateBundle parses the value of a certificate's secret, or of
+// an import request, which is either base64 encoded PKCS#12 or PEM. Since
+// clients do not always follow the content type, both are tried.
+func parseCertificateBundle(value []byte, password string) (*certificateBundle, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(value)))
+	if err == nil {
+		if key, leaf, chain, err := pkcs12.DecodeChain(decoded, password); err == nil {
+			return &certificateBundle{
+				key:         key,
+				leaf:        leaf,
+				chain:       chain,
+				contentType: pkcs12ContentType,
+			}, nil
+		}
+		value = decoded
+	}
+	return parsePEMBundle(value)
+}
+
+func parsePEMBundle(data []byte) (*certificateBundle, error) {
+	bundle := &certificateBundle{contentType: pemContentType}
+	var certificates []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not parse certificate")
+			}
+			certificates = append(certificates, certificate)
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			key, err := parsePrivateKey(block)
+			if err != nil {
+				return nil, err
+			}
+			bundle.key = key
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("value is neither PKCS#12 nor PEM with a certificate")
+	}
+
+	// The leaf is the certificate matching the private key, or the first one
+	leafIndex := 0
+	if bundle.key != nil {
+		for i, certificate := range certificates {
+			if publicKeyMatches(bundle.key, certificate) {
+				leafIndex = i
+				break
+			}
+		}
+	}
+	bundle.leaf = certificates[leafIndex]
+	for i, certificate := range certificates {
+		if i != leafIndex {
+			bundle.chain = append(bundle.chain, certificate)
+		}
+	}
+	return bundle, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	var key crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse private key")
+	}
+	return key, nil
+}
+
+func publicKeyMatches(key crypto.PrivateKey, certificate *x509.Certificate) bool {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key.PublicKey.Equal(certificate.PublicKey)
+	case *ecdsa.PrivateKey:
+		return key.PublicKey.Equal(certificate.PublicKey)
+	case ed25519.PrivateKey:
+		return key.Public().(ed25519.PublicKey).Equal(certificate.PublicKey)
+	default:
+		return false
+	}
+}
+
+// marshalPrivateKeyPEM encodes a private key as PKCS#8 PEM.
+func marshalPrivateKeyPEM(key crypto.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode private key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// errKeyNotExportable is returned for certificates whose secret holds no
+// private key, because it was created as not exportable.
+var errKeyNotExportable = errors.WithMessage(syscall.ENODATA, "the certificate's key is not exportable")
+
+// fullChain returns the leaf followed by its issuers, ordered from the leaf
+// up. Self-signed roots are left out, as servers should not send them.
+// Certificates not on the leaf's path are appended in their stored order.
+func (bundle *certificateBundle) fullChain() []*x509.Certificate {
+	chain := []*x509.Certificate{bundle.leaf}
+	remaining := append([]*x509.Certificate{}, bundle.chain...)
+	for current := bundle.leaf; ; {
+		issuer := -1
+		for i, candidate := range remaining {
+			if bytes.Equal(current.RawIssuer, candidate.RawSubject) {
+				issuer = i
+				break
+			}
+		}
+		if issuer < 0 {
+			break
+		}
+		current = remaining[issuer]
+		remaining = append(remaining[:issuer], remaining[issuer+1:]...)
+		chain = append(chain, current)
+	}
+	chain = append(chain, remaining...)
+
+	var withoutRoots []*x509.Certificate
+	for i, certificate := range chain {
+		if i == 0 || !bytes.Equal(certificate.RawIssuer, certificate.RawSubject) {
+			withoutRoots = append(withoutRoots, certificate)
+		}
+	}
+	return withoutRoots
+}
+
+// fullChainPEM encodes the full chain as PEM.
+func (bundle *certificateBundle) fullChainPEM() []byte {
+	var data []byte
+	for _, certificate := range bundle.fullChain() {
+		data = append(data, encodeCertificate(certificate.Raw)...)
+	}
+	return data
+}
+
+// keyPEM encodes the private key as PKCS#8 PEM.
+func (bundle *certificateBundle) keyPEM() ([]byte, error) {
+	if bundle.key == nil {
+		return nil, errKeyNotExportable
+	}
+	return marshalPrivateKeyPEM(bundle.key)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate is a certificate of a test hierarchy with its key.
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// issueTestCertificate creates a certificate signed by issuer, or a
+// self-signed root if issuer is nil. The issuer can be downloaded from
+// issuerURL, if given.
+func issueTestCertificate(t *testing.T, commonName string, issuer *testCertificate, isCA bool, issuerURL ...string) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return signTestCertificate(t, commonName, key, issuer, isCA, issuerURL...)
+}
+
+// signTestCertificate is issueTestCertificate for an existing key, to
+// issue several certificates for the same key.
+func signTestCertificate(t *testing.T, commonName string, key *ecdsa.PrivateKey, issuer *testCertificate,
+	isCA bool, issuerURL ...string) *testCertificate {
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IssuingCertificateURL: issuerURL,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{commonName}
+	}
+	parent, signer := template, key
+	if issuer != nil {
+		parent, signer = issuer.certificate, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCertificate{certificate: certificate, key: key}
+}
+
+// testHierarchy creates a root, an intermediate and a leaf certificate.
+func testHierarchy(t *testing.T) (root, intermediate, leaf *testCertificate) {
+	root = issueTestCertificate(t, "Test Root", nil, true)
+	intermediate = issueTestCertificate(t, "Test Intermediate", root, true)
+	leaf = issueTestCertificate(t, "example.com", intermediate, false)
+	return root, intermediate, leaf
+}
+
+func pemCertificates(data []byte) []string {
+	var names []string
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return names
+		}
+		if block.Type != "CERTIFICATE" {
+			names = append(names, block.Type)
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		names = append(names, certificate.Subject.CommonName)
+	}
+}
+
+func Test_certificateBundleViews(t *testing.T) {
+	ctx := context.Background()
+	root, intermediate, leaf := testHierarchy(t)
+	keyPEM, err := marshalPrivateKeyPEM(leaf.key)
+	require.NoError(t, err)
+	// Stored out of order, with the root
+	var value []byte
+	value = append(value, encodeCertificate(root.certificate.Raw)...)
+	value = append(value, keyPEM...)
+	value = append(value, encodeCertificate(leaf.certificate.Raw)...)
+	value = append(value, encodeCertificate(intermediate.certificate.Raw)...)
+
+	backend, err := NewLocalBackend(t.TempDir())
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(value)
+	_, err = backend.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &encoded,
+	})
+	require.NoError(t, err)
+	certificates, err := newTestRoot(backend).Find(certificatesDirName, ctx)
+	require.NoError(t, err)
+
+	download := func(name string) []byte {
+		entry, err := certificates.Find(name, ctx)
+		require.NoError(t, err)
+		require.NotNil(t, entry, name)
+		data, err := entry.Download(ctx)
+		require.NoError(t, err)
+		return data
+	}
+	assert.Equal(t, []string{"PRIVATE KEY"}, pemCertificates(download("web.key.pem")))
+	assert.Equal(t, []string{"example.com", "Test Intermediate"}, pemCertificates(download("web.fullchain.pem")),
+		"the chain is ordered from the leaf up and leaves out the root")
+	assert.Equal(t, []string{"PRIVATE KEY", "example.com", "Test Intermediate"}, pemCertificates(download("web.bundle.pem")))
+
+	bundle := &certificateBundle{leaf: leaf.certificate}
+	_, err = viewFor(certificateKind, ".key.pem").render(ctx, bundle)
+	assert.Equal(t, syscall.ENODATA, errnoFor(err), "certificates without an exportable key")
+}
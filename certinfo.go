@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/pkg/errors"
+)
+
+// certificateInfo is what `openssl x509 -text` is usually run for, in the
+// .info and .info.json files of a certificate.
+type certificateInfo struct {
+	Subject          string    `json:"subject"`
+	Issuer           string    `json:"issuer"`
+	SerialNumber     string    `json:"serialNumber"`
+	NotBefore        time.Time `json:"notBefore"`
+	NotAfter         time.Time `json:"notAfter"`
+	DaysRemaining    int       `json:"daysRemaining"`
+	DNSNames         []string  `json:"dnsNames,omitempty"`
+	IPAddresses      []string  `json:"ipAddresses,omitempty"`
+	EmailAddresses   []string  `json:"emailAddresses,omitempty"`
+	URIs             []string  `json:"uris,omitempty"`
+	PublicKey        string    `json:"publicKey"`
+	KeyUsage         []string  `json:"keyUsage,omitempty"`
+	ExtendedKeyUsage []string  `json:"extendedKeyUsage,omitempty"`
+	IsCA             bool      `json:"isCA"`
+	SHA256           string    `json:"sha256"`
+	SHA1             string    `json:"sha1"`
+	// Thumbprint is the x5t Key Vault reports, the base64url SHA-1.
+	Thumbprint string `json:"thumbprint,omitempty"`
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// fingerprint formats a hash like `openssl x509 -fingerprint`.
+func fingerprint(sum []byte) string {
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+func describePublicKey(certificate *x509.Certificate) string {
+	switch key := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return certificate.PublicKeyAlgorithm.String()
+	}
+}
+
+// parseCertificateInfo describes the certificate of a Key Vault certificate
+// as of now.
+func parseCertificateInfo(certificate azcertificates.Certificate, now time.Time) (*certificateInfo, error) {
+	parsed, err := x509.ParseCertificate(certificate.CER)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse certificate")
+	}
+	sha256Sum := sha256.Sum256(parsed.Raw)
+	sha1Sum := sha1.Sum(parsed.Raw)
+	info := &certificateInfo{
+		Subject:        parsed.Subject.String(),
+		Issuer:         parsed.Issuer.String(),
+		SerialNumber:   fingerprint(parsed.SerialNumber.Bytes()),
+		NotBefore:      parsed.NotBefore.UTC(),
+		NotAfter:       parsed.NotAfter.UTC(),
+		DaysRemaining:  int(math.Floor(parsed.NotAfter.Sub(now).Hours() / 24)),
+		DNSNames:       parsed.DNSNames,
+		EmailAddresses: parsed.EmailAddresses,
+		PublicKey:      describePublicKey(parsed),
+		IsCA:           parsed.IsCA,
+		SHA256:         fingerprint(sha256Sum[:]),
+		SHA1:           fingerprint(sha1Sum[:]),
+	}
+	for _, ip := range parsed.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range parsed.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	for _, usage := range keyUsageNames {
+		if parsed.KeyUsage&usage.usage != 0 {
+			info.KeyUsage = append(info.KeyUsage, usage.name)
+		}
+	}
+	for _, usage := range parsed.ExtKeyUsage {
+		name, ok := extKeyUsageNames[usage]
+		if !ok {
+			name = fmt.Sprintf("unknown (%d)", usage)
+		}
+		info.ExtendedKeyUsage = append(info.ExtendedKeyUsage, name)
+	}
+	if len(certificate.X509Thumbprint) != 0 {
+		info.Thumbprint = base64.RawURLEncoding.EncodeToString(certificate.X509Thumbprint)
+	}
+	return info, nil
+}
+
+// text formats the info as aligned lines.
+func (info *certificateInfo) text() []byte {
+	var buffer bytes.Buffer
+	writer := tabwriter.NewWriter(&buffer, 0, 0, 1, ' ', 0)
+	line := func(label string, value string) {
+		if len(value) != 0 {
+			fmt.Fprintf(writer, "%s:\t%s\n", label, value)
+		}
+	}
+	line("Subject", info.Subject)
+	line("Issuer", info.Issuer)
+	line("Serial number", info.SerialNumber)
+	line("Not before", info.NotBefore.Format(time.RFC3339))
+	line("Not after", info.NotAfter.Format(time.RFC3339))
+	if info.DaysRemaining < 0 {
+		line("Expired", fmt.Sprintf("%d days ago", -info.DaysRemaining))
+	} else {
+		line("Expires in", fmt.Sprintf("%d days", info.DaysRemaining))
+	}
+	line("DNS names", strings.Join(info.DNSNames, ", "))
+	line("IP addresses", strings.Join(info.IPAddresses, ", "))
+	line("Email addresses", strings.Join(info.EmailAddresses, ", "))
+	line("URIs", strings.Join(info.URIs, ", "))
+	line("Public key", info.PublicKey)
+	line("Key usage", strings.Join(info.KeyUsage, ", "))
+	line("Extended key usage", strings.Join(info.ExtendedKeyUsage, ", "))
+	line("CA", fmt.Sprint(info.IsCA))
+	line("SHA-256", info.SHA256)
+	line("SHA-1", info.SHA1)
+	line("Thumbprint", info.Thumbprint)
+	_ = writer.Flush()
+	return buffer.Bytes()
+}
+
+func (info *certificateInfo) json() ([]byte, error) {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
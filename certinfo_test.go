@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fingerprint(t *testing.T) {
+	assert.Equal(t, "00:AB:FF", fingerprint([]byte{0x00, 0xab, 0xff}))
+}
+
+func Test_parseCertificateInfo(t *testing.T) {
+	_, intermediate, leaf := testHierarchy(t)
+	thumbprint := sha1.Sum(leaf.certificate.Raw)
+	certificate := azcertificates.Certificate{CER: leaf.certificate.Raw, X509Thumbprint: thumbprint[:]}
+
+	info, err := parseCertificateInfo(certificate, leaf.certificate.NotAfter.Add(-49*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "CN=example.com", info.Subject)
+	assert.Equal(t, "CN=Test Intermediate", info.Issuer)
+	assert.Equal(t, []string{"example.com"}, info.DNSNames)
+	assert.Equal(t, "ECDSA P-256", info.PublicKey)
+	assert.Equal(t, 2, info.DaysRemaining)
+	assert.Equal(t, fingerprint(thumbprint[:]), info.SHA1)
+	assert.Len(t, info.SHA256, 32*3-1)
+	assert.NotEmpty(t, info.Thumbprint)
+
+	info, err = parseCertificateInfo(azcertificates.Certificate{CER: intermediate.certificate.Raw},
+		intermediate.certificate.NotAfter.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, info.IsCA)
+	assert.Equal(t, []string{"keyCertSign"}, info.KeyUsage)
+	assert.Equal(t, -1, info.DaysRemaining)
+	assert.Contains(t, string(info.text()), "Expired:")
+
+	_, err = parseCertificateInfo(azcertificates.Certificate{CER: []byte("garbage")}, time.Now())
+	assert.Error(t, err)
+}
+
+func Test_certificateInfoViews(t *testing.T) {
+	ctx := context.Background()
+	_, _, leaf := testHierarchy(t)
+	certificate := azcertificates.Certificate{CER: leaf.certificate.Raw}
+
+	data, err := viewFor(certificateKind, ".info").render(ctx, certificate)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "DNS names:")
+	assert.Contains(t, string(data), "example.com")
+
+	data, err = viewFor(certificateKind, ".info.json").render(ctx, certificate)
+	require.NoError(t, err)
+	var info certificateInfo
+	require.NoError(t, json.Unmarshal(data, &info))
+	assert.Equal(t, "CN=example.com", info.Subject)
+
+	data, err = viewFor(certificateKind, ".sha1").render(ctx, certificate)
+	require.NoError(t, err)
+	assert.Equal(t, info.SHA1+"\n", string(data))
+	data, err = viewFor(certificateKind, ".sha256").render(ctx, certificate)
+	require.NoError(t, err)
+	assert.Equal(t, info.SHA256+"\n", string(data))
+}
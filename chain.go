@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxChainLength is the most certificates, including the leaf, that
+	// chain building follows before giving up.
+	maxChainLength = 10
+	// maxIssuerDownloadSize limits what is read from an AIA URL.
+	maxIssuerDownloadSize = 1 << 20
+)
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// pkcs7ContentInfo and pkcs7SignedData are the parts of a PKCS#7 file
+// needed to get at its certificates, as served by CAs as .p7c.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// parsePKCS7Certificates returns the certificates of a DER PKCS#7 file.
+func parsePKCS7Certificates(der []byte) ([]*x509.Certificate, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, errors.Wrap(err, "could not parse PKCS#7")
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, errors.Errorf("unsupported PKCS#7 content type %s", info.ContentType)
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, errors.Wrap(err, "could not parse PKCS#7 signed data")
+	}
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse PKCS#7 certificates")
+	}
+	return certificates, nil
+}
+
+// parseCertificates reads certificates in any of the forms CAs serve them
+// in: DER, PEM or PKCS#7, itself either DER or PEM.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		var certificates []*x509.Certificate
+		for rest := data; ; {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			var parsed []*x509.Certificate
+			var err error
+			switch block.Type {
+			case "CERTIFICATE":
+				parsed, err = x509.ParseCertificates(block.Bytes)
+			case "PKCS7":
+				parsed, err = parsePKCS7Certificates(block.Bytes)
+			default:
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, parsed...)
+		}
+		if len(certificates) == 0 {
+			return nil, errors.New("no certificates in PEM data")
+		}
+		return certificates, nil
+	}
+	if certificates, err := x509.ParseCertificates(data); err == nil {
+		return certificates, nil
+	}
+	return parsePKCS7Certificates(data)
+}
+
+// isSelfSigned reports whether certificate is its own issuer, i.e. a root.
+func isSelfSigned(certificate *x509.Certificate) bool {
+	return bytes.Equal(certificate.RawSubject, certificate.RawIssuer) &&
+		certificate.CheckSignature(certificate.SignatureAlgorithm, certificate.RawTBSCertificate, certificate.Signature) == nil
+}
+
+// downloadCertificates retrieves the certificates at an AIA URL.
+func downloadCertificates(ctx context.Context, location string) ([]*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxIssuerDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxIssuerDownloadSize {
+		return nil, errors.Errorf("response is larger than %d bytes", maxIssuerDownloadSize)
+	}
+	return parseCertificates(data)
+}
+
+// buildCertificateChain returns the certificate in der followed by its
+// issuers, as far as the issuer store finds them. It stops at a self-signed
+// root or a certificate whose issuer cannot be found or downloaded, and fails
+// only if the chain loops or grows too long.
+func buildCertificateChain(ctx context.Context, der []byte) ([]*x509.Certificate, error) {
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse certificate")
+	}
+	chain := []*x509.Certificate{leaf}
+	seen := map[string]bool{string(leaf.Raw): true}
+	for current := leaf; !isSelfSigned(current); {
+		issuer, err := issuers.issuerOf(ctx, current)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Println("Ending the certificate chain of", leaf.Subject, "at", current.Subject.String()+":", err)
+			break
+		}
+		if issuer == nil {
+			break
+		}
+		if seen[string(issuer.Raw)] {
+			return nil, errors.Errorf("certificate chain of %s loops back to %s", leaf.Subject, issuer.Subject)
+		}
+		if len(chain) == maxChainLength {
+			return nil, errors.Errorf("certificate chain of %s is longer than %d certificates", leaf.Subject, maxChainLength)
+		}
+		seen[string(issuer.Raw)] = true
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain, nil
+}
+
+// certChain encodes the chain of a certificate as PEM, without the root.
+func certChain(ctx context.Context, data []byte) ([]byte, error) {
+	chain, err := buildCertificateChain(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	var chainBytes bytes.Buffer
+	for _, certificate := range chain {
+		if isSelfSigned(certificate) {
+			continue
+		}
+		chainBytes.Write(encodeCertificate(certificate.Raw))
+	}
+	return chainBytes.Bytes(), nil
+}
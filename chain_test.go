@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_buildCertificateChain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("downloads issuers from the internet")
+	}
+	conn, err := tls.Dial("tcp", "letsencrypt.org:443", &tls.Config{})
+	if err != nil {
+		t.Skip("letsencrypt.org is not reachable:", err)
+	}
+	defer conn.Close()
+	useIssuers(t, &issuerStore{config: chainConfig{Download: true}})
+	cert := conn.ConnectionState().PeerCertificates[0].Raw
+
+	chain, err := buildCertificateChain(context.Background(), cert)
+	require.NoError(t, err)
+	assert.Greater(t, len(chain), 1, "the issuers of the certificate are found")
+}
+
+// aiaServer serves issuer certificates by path.
+type aiaServer struct {
+	*httptest.Server
+	files map[string][]byte
+}
+
+func newAIAServer(t *testing.T) *aiaServer {
+	server := &aiaServer{files: map[string][]byte{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := server.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func marshalPKCS7(t *testing.T, certificates ...*x509.Certificate) []byte {
+	var raw []byte
+	for _, certificate := range certificates {
+		raw = append(raw, certificate.Raw...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	data, err := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})
+	require.NoError(t, err)
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      asn1.RawValue{FullBytes: data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      emptySet,
+	})
+	require.NoError(t, err)
+	info, err := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	require.NoError(t, err)
+	return info
+}
+
+func commonNames(chain []*x509.Certificate) []string {
+	var names []string
+	for _, certificate := range chain {
+		names = append(names, certificate.Subject.CommonName)
+	}
+	return names
+}
+
+func Test_parseCertificates(t *testing.T) {
+	root, intermediate, _ := testHierarchy(t)
+	both := []*x509.Certificate{root.certificate, intermediate.certificate}
+	for name, data := range map[string][]byte{
+		"DER":        intermediate.certificate.Raw,
+		"PEM":        encodeCertificate(intermediate.certificate.Raw),
+		"PKCS#7":     marshalPKCS7(t, both...),
+		"PEM PKCS#7": pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: marshalPKCS7(t, both...)}),
+	} {
+		certificates, err := parseCertificates(data)
+		require.NoError(t, err, name)
+		assert.Contains(t, commonNames(certificates), "Test Intermediate", name)
+	}
+	_, err := parseCertificates([]byte("garbage"))
+	assert.Error(t, err)
+}
+
+func Test_buildCertificateChain_AIA(t *testing.T) {
+	ctx := context.Background()
+	server := newAIAServer(t)
+	useIssuers(t, &issuerStore{config: chainConfig{Download: true}})
+	rootCA := issueTestCertificate(t, "Root", nil, true)
+	intermediateCA := issueTestCertificate(t, "Intermediate", rootCA, true, server.URL+"/root.p7c")
+	root, intermediate := rootCA.certificate, intermediateCA.certificate
+	leaf := issueTestCertificate(t, "Leaf", intermediateCA, false, server.URL+"/intermediate.crt").certificate
+	server.files["/intermediate.crt"] = intermediate.Raw
+	server.files["/root.p7c"] = marshalPKCS7(t, root)
+
+	chain, err := buildCertificateChain(ctx, leaf.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Leaf", "Intermediate", "Root"}, commonNames(chain))
+	data, err := certChain(ctx, leaf.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Leaf", "Intermediate"}, pemCertificates(data), "the root is left out")
+
+	t.Run("issuer not found", func(t *testing.T) {
+		delete(server.files, "/root.p7c")
+		t.Cleanup(func() { server.files["/root.p7c"] = marshalPKCS7(t, root) })
+		chain, err := buildCertificateChain(ctx, leaf.Raw)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Leaf", "Intermediate"}, commonNames(chain), "the chain ends before the missing issuer")
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		server.files["/intermediate.crt"] = root.Raw
+		t.Cleanup(func() { server.files["/intermediate.crt"] = intermediate.Raw })
+		chain, err := buildCertificateChain(ctx, leaf.Raw)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Leaf"}, commonNames(chain), "the wrong issuer is not added")
+	})
+
+	t.Run("not a certificate", func(t *testing.T) {
+		_, err := certChain(ctx, []byte("garbage"))
+		assert.ErrorContains(t, err, "could not parse certificate")
+	})
+}
+
+func Test_buildCertificateChain_loop(t *testing.T) {
+	server := newAIAServer(t)
+	useIssuers(t, &issuerStore{config: chainConfig{Download: true}})
+	a, b := issueTestCertificate(t, "A", nil, true), issueTestCertificate(t, "B", nil, true)
+	// A and B have signed each other
+	server.files["/a"] = signTestCertificate(t, "A", a.key, b, true, server.URL+"/b").certificate.Raw
+	server.files["/b"] = signTestCertificate(t, "B", b.key, a, true, server.URL+"/a").certificate.Raw
+	leaf := issueTestCertificate(t, "Leaf", a, false, server.URL+"/a").certificate
+
+	_, err := buildCertificateChain(context.Background(), leaf.Raw)
+	assert.ErrorContains(t, err, "loops")
+}
+
+func Test_buildCertificateChain_depth(t *testing.T) {
+	server := newAIAServer(t)
+	useIssuers(t, &issuerStore{config: chainConfig{Download: true}})
+	chain := make([]*testCertificate, maxChainLength+1)
+	// Each certificate is issued by the next one, up to a root
+	var issuer *testCertificate
+	for i := maxChainLength; i >= 0; i-- {
+		chain[i] = issueTestCertificate(t, fmt.Sprint(i), issuer, true, fmt.Sprintf("%s/%d", server.URL, i+1))
+		server.files[fmt.Sprintf("/%d", i)] = chain[i].certificate.Raw
+		issuer = chain[i]
+	}
+
+	_, err := buildCertificateChain(context.Background(), chain[0].certificate.Raw)
+	assert.ErrorContains(t, err, "longer than")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// cloudConfig selects the Azure cloud to authenticate in and the Key Vault
+// endpoints of that cloud. Empty values use the defaults of the named
+// cloud.
+type cloudConfig struct {
+	// Name is one of the keys of knownClouds, or "custom".
+	Name          string `yaml:"name"`
+	AuthorityHost string `yaml:"authority_host"`
+	// Audience is the Key Vault resource tokens are requested for. Setting
+	// it overrides the resource Key Vault announces in its challenges.
+	Audience string `yaml:"audience"`
+	// DNSSuffix completes vault URLs given only as a name.
+	DNSSuffix string `yaml:"dns_suffix"`
+}
+
+// knownClouds are the Azure clouds with their Key Vault endpoints.
+var knownClouds = map[string]cloudConfig{
+	"public": {
+		AuthorityHost: cloud.AzurePublic.ActiveDirectoryAuthorityHost,
+		Audience:      "https://vault.azure.net",
+		DNSSuffix:     "vault.azure.net",
+	},
+	"china": {
+		AuthorityHost: cloud.AzureChina.ActiveDirectoryAuthorityHost,
+		Audience:      "https://vault.azure.cn",
+		DNSSuffix:     "vault.azure.cn",
+	},
+	"usgovernment": {
+		AuthorityHost: cloud.AzureGovernment.ActiveDirectoryAuthorityHost,
+		Audience:      "https://vault.usgovcloudapi.net",
+		DNSSuffix:     "vault.usgovcloudapi.net",
+	},
+}
+
+var cloudNames = []string{"public", "china", "usgovernment", "custom"}
+
+// resolved fills in the defaults of the named cloud.
+func (config cloudConfig) resolved() cloudConfig {
+	defaults := knownClouds[config.Name]
+	if len(config.Name) == 0 {
+		defaults = knownClouds["public"]
+	}
+	if len(config.AuthorityHost) == 0 {
+		config.AuthorityHost = defaults.AuthorityHost
+	}
+	if len(config.Audience) == 0 {
+		config.Audience = defaults.Audience
+	}
+	if len(config.DNSSuffix) == 0 {
+		config.DNSSuffix = defaults.DNSSuffix
+	}
+	return config
+}
+
+// configuration is the cloud configuration for azidentity.
+func (config cloudConfig) configuration() cloud.Configuration {
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: config.resolved().AuthorityHost,
+		Services:                     map[cloud.ServiceName]cloud.ServiceConfiguration{},
+	}
+}
+
+// scope is the token scope for Key Vault in this cloud.
+func (config cloudConfig) scope() string {
+	return strings.TrimSuffix(config.resolved().Audience, "/") + "/.default"
+}
+
+// vaultURL completes a vault given only by its name with the cloud's DNS
+// suffix.
+func (config cloudConfig) vaultURL(vault string) string {
+	if len(vault) == 0 || strings.ContainsAny(vault, ":/.") {
+		return vault
+	}
+	return "https://" + vault + "." + config.resolved().DNSSuffix
+}
+
+func validateCloud(report func(format string, args ...any), config cloudConfig) {
+	if !contains(cloudNames, config.Name) {
+		report("cloud.name %q is not one of %s", config.Name, strings.Join(cloudNames, ", "))
+		return
+	}
+	if config.Name == "custom" &&
+		(len(config.AuthorityHost) == 0 || len(config.Audience) == 0 || len(config.DNSSuffix) == 0) {
+		report("cloud %q needs authority_host, audience and dns_suffix", config.Name)
+	}
+	for key, value := range map[string]string{"authority_host": config.AuthorityHost, "audience": config.Audience} {
+		if len(value) == 0 {
+			continue
+		}
+		if parsed, err := url.Parse(value); err != nil || parsed.Scheme != "https" || len(parsed.Host) == 0 {
+			report("cloud.%s %q is not an https URL", key, value)
+		}
+	}
+}
+
+// audienceCredential requests tokens for a fixed Key Vault audience instead
+// of the resource from the vault's challenge, for clouds whose vaults
+// announce a different one.
+type audienceCredential struct {
+	credential azcore.TokenCredential
+	scope      string
+}
+
+func (audience *audienceCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	options.Scopes = []string{audience.scope}
+	return audience.credential.GetToken(ctx, options)
+}
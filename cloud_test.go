@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cloudConfig(t *testing.T) {
+	config, _, err := parseConfig([]string{"-config", writeConfig(t, `
+cloud:
+  name: usgovernment
+vaults:
+  - name: app
+    url: app
+  - name: other
+    url: https://other.vault.usgovcloudapi.net
+`), "/mnt"})
+	require.NoError(t, err)
+	require.NoError(t, config.validate())
+	assert.Equal(t, "https://app.vault.usgovcloudapi.net", config.Vaults[0].URL, "names are completed with the DNS suffix")
+	assert.Equal(t, "https://other.vault.usgovcloudapi.net", config.Vaults[1].URL)
+	assert.Equal(t, "https://login.microsoftonline.us/", config.Cloud.configuration().ActiveDirectoryAuthorityHost)
+	assert.Equal(t, "https://vault.usgovcloudapi.net/.default", config.Cloud.scope())
+
+	config, _, err = parseConfig([]string{"-cloud", "china", "-url", "app", "/mnt"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.vault.azure.cn", config.URL)
+
+	custom := cloudConfig{Name: "custom", AuthorityHost: "https://login.example.com/", DNSSuffix: "vault.example.com"}
+	assert.Equal(t, "https://app.vault.example.com", custom.vaultURL("app"))
+	config, _, err = parseConfig([]string{"-cloud", "custom", "-authority-host", "http://login.example.com", "/mnt"})
+	require.NoError(t, err)
+	err = config.validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "needs authority_host, audience and dns_suffix")
+	assert.Contains(t, err.Error(), `cloud.authority_host "http://login.example.com" is not an https URL`)
+}
+
+type scopeRecorder struct {
+	scopes []string
+}
+
+func (recorder *scopeRecorder) GetToken(_ context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	recorder.scopes = options.Scopes
+	return azcore.AccessToken{}, nil
+}
+
+func Test_audienceCredential(t *testing.T) {
+	recorder := &scopeRecorder{}
+	credential := &audienceCredential{credential: recorder, scope: cloudConfig{Audience: "https://vault.example.com/"}.scope()}
+	_, err := credential.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{"https://vault.azure.net/.default"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://vault.example.com/.default"}, recorder.scopes)
+}
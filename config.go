@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all mount options. They can be given in a YAML file with
+// -config; flags given on the command line override values from the file.
+type Config struct {
+	MountPoint string `yaml:"mount_point"`
+	URL        string `yaml:"url"`
+	Local      string `yaml:"local"`
+	// HSM marks url as a Managed HSM, for HSMs not under a managedhsm
+	// domain.
+	HSM    bool          `yaml:"hsm"`
+	Vaults []vaultConfig `yaml:"vaults"`
+	// Overlay merges the vaults without their own mount point into one
+	// tree instead of showing them as subdirectories.
+	Overlay     bool              `yaml:"overlay"`
+	Cloud       cloudConfig       `yaml:"cloud"`
+	Credentials credentialConfig  `yaml:"credentials"`
+	Connection  connectionConfig  `yaml:"connection"`
+	HTTP        httpConfig        `yaml:"http"`
+	Timeouts    operationTimeouts `yaml:"timeouts"`
+	Cache       cacheConfig       `yaml:"cache"`
+	Layout      directoryLayout   `yaml:"layout"`
+	Filters     objectFilter      `yaml:"filters"`
+	Permissions filePermissions   `yaml:"permissions"`
+	Keystores   keystoreConfig    `yaml:"keystores"`
+	Chains      chainConfig       `yaml:"chains"`
+	Hooks       mountHooks        `yaml:"hooks"`
+}
+
+type connectionConfig struct {
+	MaxRetries       int           `yaml:"max_retries"`
+	RetryDelay       time.Duration `yaml:"retry_delay"`
+	MaxRetryDelay    time.Duration `yaml:"max_retry_delay"`
+	RateLimit        float64       `yaml:"rate_limit"`
+	RateBurst        int           `yaml:"rate_burst"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+}
+
+type cacheConfig struct {
+	// ListingTTL is how long directory listings, and the objects fetched for
+	// their files, are served before they are retrieved again.
+	ListingTTL time.Duration `yaml:"listing_ttl"`
+	DirectIO   bool          `yaml:"direct_io"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Cloud:       cloudConfig{Name: "public"},
+		Credentials: credentialConfig{Type: "default"},
+		Connection: connectionConfig{
+			MaxRetries:       3,
+			RetryDelay:       800 * time.Millisecond,
+			MaxRetryDelay:    30 * time.Second,
+			RateLimit:        100,
+			RateBurst:        50,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+		},
+		HTTP:     defaultHTTPConfig,
+		Timeouts: timeouts,
+		Cache: cacheConfig{
+			ListingTTL: cooldownTime,
+			DirectIO:   directIO,
+		},
+		Layout:      layout,
+		Filters:     filters,
+		Permissions: permissions,
+		Keystores:   keystores,
+		Chains:      defaultChains(),
+	}
+}
+
+// fileMode is a permission mode written in octal, like 0440.
+type fileMode os.FileMode
+
+func (mode fileMode) String() string {
+	return fmt.Sprintf("%04o", uint32(mode))
+}
+
+func (mode *fileMode) Set(value string) error {
+	parsed, err := strconv.ParseUint(strings.TrimPrefix(value, "0o"), 8, 32)
+	if err != nil {
+		return errors.Errorf("invalid file mode %q, expected octal like 0440", value)
+	}
+	*mode = fileMode(parsed)
+	return nil
+}
+
+func (mode *fileMode) UnmarshalYAML(node *yaml.Node) error {
+	return mode.Set(node.Value)
+}
+
+// stringList is a flag that can be given multiple times. The first use
+// replaces the default, so flags replace lists from the config file.
+type stringList struct {
+	values *[]string
+	set    bool
+}
+
+func (list *stringList) String() string {
+	if list.values == nil {
+		return ""
+	}
+	return strings.Join(*list.values, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	if !list.set {
+		*list.values = nil
+		list.set = true
+	}
+	*list.values = append(*list.values, value)
+	return nil
+}
+
+// flagSet defines the command line flags, storing into config.
+func (config *Config) flagSet() (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.Usage = func() {
+		usage(flags)
+	}
+	configFile := flags.String("config", "", "YAML file with mount options; flags override its values")
+
+	flags.StringVar(&config.URL, "url", config.URL, "URL of Azure Key Vault")
+	flags.StringVar(&config.Local, "local", config.Local,
+		"Directory of a local fake vault to mount instead of Azure Key Vault (for development and testing)")
+	flags.BoolVar(&config.HSM, "hsm", config.HSM,
+		"The -url is a Managed HSM, which only has keys (detected for *.managedhsm.* URLs)")
+	flags.Var(&vaultList{vaults: &config.Vaults}, "vault",
+		"Mount a vault as a subdirectory, given as name=url or name=directory (may be repeated)")
+	flags.BoolVar(&config.Overlay, "overlay", config.Overlay,
+		"Merge the -vault vaults into one tree; the first vault containing a name wins")
+	flags.StringVar(&config.Cloud.Name, "cloud", config.Cloud.Name,
+		"Azure cloud: "+strings.Join(cloudNames, ", "))
+	flags.StringVar(&config.Cloud.AuthorityHost, "authority-host", config.Cloud.AuthorityHost,
+		"Azure AD authority host, instead of the cloud's")
+	flags.StringVar(&config.Cloud.Audience, "vault-audience", config.Cloud.Audience,
+		"Key Vault resource to request tokens for, instead of the one announced by the vault")
+	flags.StringVar(&config.Cloud.DNSSuffix, "vault-dns-suffix", config.Cloud.DNSSuffix,
+		"DNS suffix completing vault URLs given only as a name, instead of the cloud's")
+	flags.StringVar(&config.Credentials.Type, "credential", config.Credentials.Type,
+		"How to authenticate: "+strings.Join(credentialTypes, ", "))
+	flags.StringVar(&config.Credentials.TenantID, "tenant-id", config.Credentials.TenantID,
+		"Azure AD tenant to authenticate in")
+	flags.StringVar(&config.Credentials.ClientID, "client-id", config.Credentials.ClientID,
+		"Client ID of the application or user-assigned managed identity")
+	flags.StringVar(&config.Credentials.ResourceID, "identity-resource-id", config.Credentials.ResourceID,
+		"Resource ID of the user-assigned managed identity, instead of -client-id")
+	flags.StringVar(&config.Credentials.ClientSecretFile, "client-secret-file", config.Credentials.ClientSecretFile,
+		"File containing the client secret (default: $AZURE_CLIENT_SECRET)")
+	flags.StringVar(&config.Credentials.Certificate, "client-certificate", config.Credentials.Certificate,
+		"PEM or PKCS#12 file with the client certificate and key (password: $AZURE_CLIENT_CERTIFICATE_PASSWORD)")
+	flags.StringVar(&config.Credentials.TokenFile, "token-file", config.Credentials.TokenFile,
+		"Federated token file of the workload identity (default: $AZURE_FEDERATED_TOKEN_FILE)")
+	flags.StringVar(&config.Credentials.TokenCache, "token-cache", config.Credentials.TokenCache,
+		"Encrypted file keeping the tokens of a device-code or browser sign-in")
+
+	connection := &config.Connection
+	flags.IntVar(&connection.MaxRetries, "max-retries", connection.MaxRetries,
+		"Maximum number of retries for a failed Key Vault request (0 = no retries)")
+	flags.DurationVar(&connection.RetryDelay, "retry-delay", connection.RetryDelay,
+		"Initial delay between retries, doubled on each retry")
+	flags.DurationVar(&connection.MaxRetryDelay, "max-retry-delay", connection.MaxRetryDelay,
+		"Maximum delay between retries; requests asking for a longer Retry-After fail instead")
+	flags.Float64Var(&connection.RateLimit, "rate-limit", connection.RateLimit,
+		"Maximum Key Vault requests per second across secrets, keys and certificates (0 = unlimited)")
+	flags.IntVar(&connection.RateBurst, "rate-burst", connection.RateBurst,
+		"Number of requests allowed to exceed -rate-limit at once")
+	flags.IntVar(&connection.BreakerThreshold, "breaker-threshold", connection.BreakerThreshold,
+		"Consecutive failed Key Vault requests after which requests fail fast (0 = disabled)")
+	flags.DurationVar(&connection.BreakerCooldown, "breaker-cooldown", connection.BreakerCooldown,
+		"How long requests fail fast before Key Vault is probed again")
+
+	httpOptions := &config.HTTP
+	flags.StringVar(&httpOptions.Proxy, "proxy", httpOptions.Proxy,
+		"Proxy URL for all requests (default: $HTTPS_PROXY / $HTTP_PROXY)")
+	flags.Var(&stringList{values: &httpOptions.NoProxy}, "no-proxy",
+		"Host, .domain or network to reach without the proxy (may be repeated)")
+	flags.StringVar(&httpOptions.CABundle, "ca-bundle", httpOptions.CABundle,
+		"PEM file with CA certificates to trust in addition to the system's")
+	flags.DurationVar(&httpOptions.DialTimeout, "dial-timeout", httpOptions.DialTimeout,
+		"Maximum time for establishing a connection (0 = no limit)")
+	flags.DurationVar(&httpOptions.TLSHandshakeTimeout, "tls-handshake-timeout", httpOptions.TLSHandshakeTimeout,
+		"Maximum time for the TLS handshake (0 = no limit)")
+	flags.DurationVar(&httpOptions.ResponseHeaderTimeout, "response-header-timeout", httpOptions.ResponseHeaderTimeout,
+		"Maximum time to wait for response headers after sending a request (0 = no limit)")
+	flags.DurationVar(&httpOptions.IdleConnTimeout, "idle-conn-timeout", httpOptions.IdleConnTimeout,
+		"How long idle connections are kept open (0 = no limit)")
+
+	flags.DurationVar(&config.Timeouts.Lookup, "lookup-timeout", config.Timeouts.Lookup,
+		"Maximum time for looking up a file or its attributes (0 = no limit)")
+	flags.DurationVar(&config.Timeouts.List, "list-timeout", config.Timeouts.List,
+		"Maximum time for retrieving a directory listing (0 = no limit)")
+	flags.DurationVar(&config.Timeouts.Read, "read-timeout", config.Timeouts.Read,
+		"Maximum time for reading a file (0 = no limit)")
+
+	flags.DurationVar(&config.Cache.ListingTTL, "listing-ttl", config.Cache.ListingTTL,
+		"How long directory listings and fetched objects are served before they are retrieved again")
+	flags.BoolVar(&config.Cache.DirectIO, "direct-io", config.Cache.DirectIO,
+		"Don't download files to determine their size; report the last known size and read until EOF")
+
+	flags.Var(&stringList{values: &config.Filters.Include}, "include",
+		"Only show objects whose name matches this pattern (may be repeated)")
+	flags.Var(&stringList{values: &config.Filters.Exclude}, "exclude",
+		"Hide objects whose name matches this pattern (may be repeated)")
+
+	flags.Var(&config.Permissions.FileMode, "file-mode", "Permissions of files")
+	flags.Var(&config.Permissions.DirMode, "dir-mode", "Permissions of directories")
+	flags.IntVar(&config.Permissions.UID, "uid", config.Permissions.UID,
+		"Owner of files and directories (-1 = root)")
+	flags.IntVar(&config.Permissions.GID, "gid", config.Permissions.GID,
+		"Group of files and directories (-1 = root)")
+	flags.BoolVar(&config.Permissions.AllowOther, "allow-other", config.Permissions.AllowOther,
+		"Allow other users to access the mount (needs user_allow_other in /etc/fuse.conf)")
+
+	flags.StringVar(&config.Keystores.PasswordTag, "keystore-password-tag", config.Keystores.PasswordTag,
+		"Certificate tag holding the password of its .p12 and .jks files")
+	flags.StringVar(&config.Keystores.PasswordSecret, "keystore-password-secret", config.Keystores.PasswordSecret,
+		"Secret holding the password of .p12 and .jks files of certificates without the tag")
+
+	flags.Var(&stringList{values: &config.Chains.Issuers}, "chain-issuers",
+		"File or directory with intermediate and root certificates for completing chains (may be repeated)")
+	flags.BoolVar(&config.Chains.SystemRoots, "chain-system-roots", config.Chains.SystemRoots,
+		"Search the system trust store for the issuers of certificates")
+	flags.BoolVar(&config.Chains.Download, "chain-download", config.Chains.Download,
+		"Download issuers not found locally from the URLs in certificates")
+	flags.StringVar(&config.Chains.CacheDir, "chain-cache", config.Chains.CacheDir,
+		"Directory to keep downloaded issuers in (empty = no cache)")
+
+	flags.StringVar(&config.Hooks.OnMount, "on-mount", config.Hooks.OnMount,
+		"Shell command to run once the file system is mounted")
+	flags.StringVar(&config.Hooks.OnUnmount, "on-unmount", config.Hooks.OnUnmount,
+		"Shell command to run after the file system is unmounted")
+	return flags, configFile
+}
+
+// parseConfig builds the configuration from the command line arguments and
+// the config file they name, if any.
+func parseConfig(args []string) (Config, *flag.FlagSet, error) {
+	config := defaultConfig()
+	flags, configFile := config.flagSet()
+	_ = flags.Parse(args)
+	if len(*configFile) != 0 {
+		config = defaultConfig()
+		if err := config.loadFile(*configFile); err != nil {
+			return config, flags, err
+		}
+		// Parse again on top of the file's values, so that flags win
+		flags, _ = config.flagSet()
+		_ = flags.Parse(args)
+	}
+	if flags.NArg() > 0 {
+		config.MountPoint = flags.Arg(0)
+	}
+	config.URL = config.Cloud.vaultURL(config.URL)
+	for i := range config.Vaults {
+		config.Vaults[i].URL = config.Cloud.vaultURL(config.Vaults[i].URL)
+	}
+	return config, flags, nil
+}
+
+// loadFile reads a YAML config file on top of config. Unknown keys are
+// errors, so that typos don't go unnoticed.
+func (config *Config) loadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "could not read config file")
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return errors.Wrapf(err, "invalid config file %s", file)
+	}
+	return nil
+}
+
+// validate reports all invalid values at once.
+func (config *Config) validate() error {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(config.URL) != 0 && len(config.Local) != 0 {
+		report("url and local cannot both be set")
+	}
+	if len(config.URL) != 0 {
+		validateURL(report, "url", config.URL)
+	}
+	if config.HSM && len(config.URL) == 0 {
+		report("hsm needs url")
+	}
+	if len(config.Vaults) != 0 {
+		config.validateVaults(report)
+	} else if config.Overlay {
+		report("overlay needs vaults")
+	}
+	validateCloud(report, config.Cloud)
+	validateCredentials(report, "credentials", config.Credentials)
+
+	connection := config.Connection
+	if connection.MaxRetries < 0 {
+		report("connection.max_retries must not be negative, 0 disables retries")
+	}
+	if connection.RetryDelay < 0 || connection.MaxRetryDelay < 0 || connection.BreakerCooldown < 0 {
+		report("connection delays must not be negative")
+	}
+	if connection.RateBurst < 0 {
+		report("connection.rate_burst must not be negative")
+	}
+	validateHTTP(report, config.HTTP)
+	if config.Timeouts.Lookup < 0 || config.Timeouts.List < 0 || config.Timeouts.Read < 0 {
+		report("timeouts must not be negative")
+	}
+	if config.Cache.ListingTTL < 0 {
+		report("cache.listing_ttl must not be negative")
+	}
+
+	names := map[string]bool{}
+	for _, name := range []string{config.Layout.Certificates, config.Layout.Keys, config.Layout.Secrets} {
+		if len(name) == 0 {
+			continue
+		}
+		if strings.Contains(name, "/") || name == "." || name == ".." {
+			report("layout directory name %q is invalid", name)
+		}
+		if names[name] {
+			report("layout directory name %q is used twice", name)
+		}
+		names[name] = true
+	}
+	if len(names) == 0 {
+		report("layout hides all directories")
+	}
+
+	for _, pattern := range append(append([]string{}, config.Filters.Include...), config.Filters.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			report("filter pattern %q is invalid", pattern)
+		}
+	}
+
+	if config.Permissions.FileMode&^0777 != 0 || config.Permissions.DirMode&^0777 != 0 {
+		report("permissions may only contain the bits 0777")
+	}
+	if config.Permissions.UID < -1 || config.Permissions.GID < -1 {
+		report("permissions.uid and gid must be -1 or an ID")
+	}
+
+	if len(problems) != 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func validateURL(report func(format string, args ...any), key string, vaultURL string) {
+	parsed, err := url.Parse(vaultURL)
+	if err != nil || parsed.Scheme != "https" || len(parsed.Host) == 0 {
+		report("%s %q is not an https URL", key, vaultURL)
+	}
+}
+
+// apply makes the configuration take effect for the file system.
+func (config *Config) apply() error {
+	if err := configureHTTP(config.HTTP); err != nil {
+		return err
+	}
+	timeouts = config.Timeouts
+	cooldownTime = config.Cache.ListingTTL
+	directIO = config.Cache.DirectIO
+	layout = config.Layout
+	filters = config.Filters
+	permissions = config.Permissions
+	hooks = config.Hooks
+	keystores = config.Keystores
+	if err := configureChains(config.Chains); err != nil {
+		return err
+	}
+	return nil
+}
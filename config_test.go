@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "azkv.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func Test_parseConfig(t *testing.T) {
+	file := writeConfig(t, `
+url: https://example.vault.azure.net
+mount_point: /mnt/vault
+connection:
+  max_retries: 5
+  retry_delay: 2s
+timeouts:
+  read: 1m
+cache:
+  listing_ttl: 1m
+layout:
+  keys: ""
+  secrets: passwords
+filters:
+  exclude: ["test-*"]
+permissions:
+  file_mode: 0400
+  uid: 1000
+hooks:
+  on_mount: systemctl reload nginx
+chains:
+  download: false
+`)
+
+	config, _, err := parseConfig([]string{"-config", file, "-max-retries", "7", "-exclude", "tmp-*"})
+	require.NoError(t, err)
+	require.NoError(t, config.validate())
+
+	assert.Equal(t, "https://example.vault.azure.net", config.URL)
+	assert.Equal(t, "/mnt/vault", config.MountPoint)
+	assert.Equal(t, 7, config.Connection.MaxRetries, "flags override the file")
+	assert.Equal(t, 2*time.Second, config.Connection.RetryDelay)
+	assert.Equal(t, 30*time.Second, config.Connection.MaxRetryDelay, "defaults are kept")
+	assert.Equal(t, time.Minute, config.Timeouts.Read)
+	assert.Equal(t, 10*time.Second, config.Timeouts.Lookup)
+	assert.Equal(t, time.Minute, config.Cache.ListingTTL)
+	assert.Equal(t, directoryLayout{Certificates: "certificates", Keys: "", Secrets: "passwords"}, config.Layout)
+	assert.Equal(t, []string{"tmp-*"}, config.Filters.Exclude, "list flags replace the file's list")
+	assert.Equal(t, fileMode(0400), config.Permissions.FileMode)
+	assert.Equal(t, fileMode(0550), config.Permissions.DirMode)
+	assert.Equal(t, 1000, config.Permissions.UID)
+	assert.Equal(t, -1, config.Permissions.GID)
+	assert.Equal(t, "systemctl reload nginx", config.Hooks.OnMount)
+	assert.False(t, config.Chains.Download)
+	assert.True(t, config.Chains.SystemRoots)
+
+	config, _, err = parseConfig([]string{"-config", file, "/mnt/other"})
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/other", config.MountPoint)
+	assert.Equal(t, []string{"test-*"}, config.Filters.Exclude)
+}
+
+func Test_parseConfig_invalid(t *testing.T) {
+	_, _, err := parseConfig([]string{"-config", writeConfig(t, "cache:\n  listing_tll: 1m\n")})
+	assert.ErrorContains(t, err, "listing_tll", "unknown keys are reported")
+
+	_, _, err = parseConfig([]string{"-config", writeConfig(t, "timeouts:\n  read: soon\n")})
+	assert.Error(t, err)
+
+	config, _, err := parseConfig([]string{"-config", writeConfig(t, `
+url: http://example.vault.azure.net
+local: ./vault
+credentials:
+  type: password
+connection:
+  max_retries: -1
+layout:
+  certificates: certs/all
+  keys: secrets
+filters:
+  include: ["[a-"]
+permissions:
+  dir_mode: 01777
+`)})
+	require.NoError(t, err)
+	err = config.validate()
+	require.Error(t, err)
+	for _, problem := range []string{
+		"url and local", "not an https URL", `"password"`, "max_retries", `"certs/all"`,
+		`"secrets" is used twice`, `"[a-"`, "0777",
+	} {
+		assert.Contains(t, err.Error(), problem)
+	}
+}
+
+func Test_retryOptions(t *testing.T) {
+	connection := defaultConfig().Connection
+	assert.Equal(t, int32(3), retryOptions(connection).MaxRetries)
+	connection.MaxRetries = 0
+	assert.Equal(t, int32(-1), retryOptions(connection).MaxRetries, "0 means no retries, not the SDK default")
+}
+
+func Test_objectFilter(t *testing.T) {
+	filter := objectFilter{Include: []string{"prod-*", "shared"}, Exclude: []string{"*-old"}}
+	assert.True(t, filter.allows("prod-db"))
+	assert.True(t, filter.allows("shared"))
+	assert.False(t, filter.allows("test-db"))
+	assert.False(t, filter.allows("prod-db-old"))
+	assert.True(t, objectFilter{}.allows("anything"))
+}
+
+func Test_mountPlans(t *testing.T) {
+	config, _, err := parseConfig([]string{"-config", writeConfig(t, `
+mount_point: /mnt/vaults
+vaults:
+  - name: app
+    url: https://app.vault.azure.net
+  - name: shared-certs
+    url: https://shared-certs.vault.azure.net
+    credentials:
+      type: default
+      tenant_id: other-tenant
+  - name: platform
+    url: https://platform.vault.azure.net
+    mount_point: /mnt/platform
+`)})
+	require.NoError(t, err)
+	require.NoError(t, config.validate())
+
+	plans := config.mountPlans()
+	require.Len(t, plans, 2)
+	assert.Equal(t, "/mnt/platform", plans[0].dir)
+	assert.False(t, plans[0].shared)
+	assert.Equal(t, "/mnt/vaults", plans[1].dir)
+	assert.True(t, plans[1].shared)
+	require.Len(t, plans[1].vaults, 2)
+	assert.Equal(t, "", config.credentials(plans[1].vaults[0]).TenantID)
+	assert.Equal(t, "other-tenant", config.credentials(plans[1].vaults[1]).TenantID)
+
+	config, _, err = parseConfig([]string{"-vault", "app=https://app.vault.azure.net", "-vault", "dev=./vault", "/mnt"})
+	require.NoError(t, err)
+	require.NoError(t, config.validate())
+	assert.Equal(t, []vaultConfig{
+		{Name: "app", URL: "https://app.vault.azure.net"},
+		{Name: "dev", Local: "./vault"},
+	}, config.Vaults)
+
+	config, _, err = parseConfig([]string{"-url", "https://app.vault.azure.net",
+		"-vault", "app=https://app.vault.azure.net", "-vault", "app=./vault", "/mnt"})
+	require.NoError(t, err)
+	err = config.validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot be used together with vaults")
+	assert.Contains(t, err.Error(), `"app" is used twice`)
+}
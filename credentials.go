@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/pkg/errors"
+)
+
+// credentialConfig selects how to authenticate to Key Vault.
+type credentialConfig struct {
+	// Type is the kind of credential, one of credentialTypes.
+	Type     string `yaml:"type"`
+	TenantID string `yaml:"tenant_id"`
+	// ClientID is the application, or the user-assigned managed identity.
+	ClientID string `yaml:"client_id"`
+	// ResourceID selects a user-assigned managed identity by its resource
+	// ID instead of its client ID.
+	ResourceID string `yaml:"resource_id"`
+	// ClientSecretFile contains the client secret. If not set, the secret
+	// is taken from AZURE_CLIENT_SECRET.
+	ClientSecretFile string `yaml:"client_secret_file"`
+	// Certificate is a PEM or PKCS#12 file with the client certificate and
+	// its private key. A password is taken from
+	// AZURE_CLIENT_CERTIFICATE_PASSWORD.
+	Certificate string `yaml:"certificate"`
+	// TokenFile is the federated token of a workload identity. If not set,
+	// it is taken from AZURE_FEDERATED_TOKEN_FILE.
+	TokenFile string `yaml:"token_file"`
+	// TokenCache is the encrypted file keeping the tokens of an interactive
+	// sign-in, by default in the user's cache directory.
+	TokenCache string `yaml:"token_cache"`
+}
+
+var credentialTypes = []string{
+	"default", "environment", "managed-identity", "workload-identity",
+	"client-secret", "client-certificate", "azure-cli", "device-code", "browser",
+}
+
+// credentialParameters lists the parameters besides tenant_id each
+// credential type uses.
+var credentialParameters = map[string][]string{
+	"managed-identity":   {"client_id", "resource_id"},
+	"workload-identity":  {"client_id", "token_file"},
+	"client-secret":      {"client_id", "client_secret_file"},
+	"client-certificate": {"client_id", "certificate"},
+	"device-code":        {"client_id", "token_cache"},
+	"browser":            {"client_id", "token_cache"},
+}
+
+// parameters returns the parameters that are set, by their config key.
+func (credentials credentialConfig) parameters() map[string]string {
+	parameters := map[string]string{}
+	for key, value := range map[string]string{
+		"client_id":          credentials.ClientID,
+		"resource_id":        credentials.ResourceID,
+		"client_secret_file": credentials.ClientSecretFile,
+		"certificate":        credentials.Certificate,
+		"token_file":         credentials.TokenFile,
+		"token_cache":        credentials.TokenCache,
+	} {
+		if len(value) != 0 {
+			parameters[key] = value
+		}
+	}
+	return parameters
+}
+
+func validateCredentials(report func(format string, args ...any), key string, credentials credentialConfig) {
+	if !contains(credentialTypes, credentials.Type) {
+		report("%s.type %q is not one of %s", key, credentials.Type, strings.Join(credentialTypes, ", "))
+		return
+	}
+	for parameter := range credentials.parameters() {
+		if !contains(credentialParameters[credentials.Type], parameter) {
+			report("%s.%s is not used by credential type %q", key, parameter, credentials.Type)
+		}
+	}
+
+	switch credentials.Type {
+	case "managed-identity":
+		if len(credentials.ClientID) != 0 && len(credentials.ResourceID) != 0 {
+			report("%s: client_id and resource_id cannot both be set", key)
+		}
+	case "client-secret", "client-certificate":
+		if len(credentials.TenantID) == 0 || len(credentials.ClientID) == 0 {
+			report("%s: credential type %q needs tenant_id and client_id", key, credentials.Type)
+		}
+		if credentials.Type == "client-certificate" && len(credentials.Certificate) == 0 {
+			report("%s: credential type %q needs certificate", key, credentials.Type)
+		}
+		if credentials.Type == "client-secret" && len(credentials.ClientSecretFile) == 0 &&
+			len(os.Getenv("AZURE_CLIENT_SECRET")) == 0 {
+			report("%s: credential type %q needs client_secret_file or AZURE_CLIENT_SECRET", key, credentials.Type)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// describe names the credential and the identity it authenticates as, for
+// error messages.
+func (credentials credentialConfig) describe() string {
+	var details []string
+	if len(credentials.TenantID) != 0 {
+		details = append(details, "tenant "+credentials.TenantID)
+	}
+	if len(credentials.ClientID) != 0 {
+		details = append(details, "client "+credentials.ClientID)
+	}
+	if len(credentials.ResourceID) != 0 {
+		details = append(details, "resource "+credentials.ResourceID)
+	}
+	if len(details) == 0 {
+		return credentials.Type
+	}
+	return fmt.Sprintf("%s (%s)", credentials.Type, strings.Join(details, ", "))
+}
+
+// describedCredential adds which credential failed to its errors. The
+// errors of azidentity only name the credential's Go type, and for
+// "default" the chain members that were tried.
+type describedCredential struct {
+	credential  azcore.TokenCredential
+	description string
+}
+
+func (described *describedCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	token, err := described.credential.GetToken(ctx, options)
+	if err != nil {
+		return token, errors.Wrapf(err, "%s credential failed", described.description)
+	}
+	return token, nil
+}
+
+// newCredential creates the credential selected by credentials, signing in
+// to the given cloud. Its requests are sent through transport, if not nil.
+func newCredential(credentials credentialConfig, cloud cloudConfig, transport policy.Transporter) (azcore.TokenCredential, error) {
+	clientOptions := azcore.ClientOptions{Cloud: cloud.configuration(), Transport: transport}
+	var credential azcore.TokenCredential
+	var err error
+	switch credentials.Type {
+	case "default":
+		credential, err = azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      credentials.TenantID,
+		})
+	case "environment":
+		credential, err = azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{
+			ClientOptions: clientOptions,
+		})
+	case "managed-identity":
+		options := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if len(credentials.ClientID) != 0 {
+			options.ID = azidentity.ClientID(credentials.ClientID)
+		} else if len(credentials.ResourceID) != 0 {
+			options.ID = azidentity.ResourceID(credentials.ResourceID)
+		}
+		credential, err = azidentity.NewManagedIdentityCredential(options)
+	case "workload-identity":
+		credential, err = azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			ClientID:      credentials.ClientID,
+			TenantID:      credentials.TenantID,
+			TokenFilePath: credentials.TokenFile,
+		})
+	case "client-secret":
+		secret := os.Getenv("AZURE_CLIENT_SECRET")
+		if len(credentials.ClientSecretFile) != 0 {
+			data, readErr := os.ReadFile(credentials.ClientSecretFile)
+			if readErr != nil {
+				return nil, errors.Wrap(readErr, "could not read the client secret")
+			}
+			secret = strings.TrimSpace(string(data))
+		}
+		credential, err = azidentity.NewClientSecretCredential(credentials.TenantID, credentials.ClientID, secret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+	case "client-certificate":
+		data, readErr := os.ReadFile(credentials.Certificate)
+		if readErr != nil {
+			return nil, errors.Wrap(readErr, "could not read the client certificate")
+		}
+		var password []byte
+		if value, found := os.LookupEnv("AZURE_CLIENT_CERTIFICATE_PASSWORD"); found {
+			password = []byte(value)
+		}
+		certificates, key, parseErr := azidentity.ParseCertificates(data, password)
+		if parseErr != nil {
+			return nil, errors.Wrapf(parseErr, "could not parse the client certificate %s", credentials.Certificate)
+		}
+		credential, err = azidentity.NewClientCertificateCredential(credentials.TenantID, credentials.ClientID,
+			certificates, key, &azidentity.ClientCertificateCredentialOptions{ClientOptions: clientOptions})
+	case "device-code", "browser":
+		credential, err = newInteractiveCredential(credentials, cloud, transport)
+	case "azure-cli":
+		credential, err = azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: credentials.TenantID,
+		})
+	default:
+		return nil, errors.Errorf("unknown credential type %q", credentials.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create %s credential", credentials.describe())
+	}
+	return &describedCredential{credential: credential, description: credentials.describe()}, nil
+}
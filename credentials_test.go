@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateCredentials(t *testing.T) {
+	t.Setenv("AZURE_CLIENT_SECRET", "")
+	config, _, err := parseConfig([]string{"-config", writeConfig(t, `
+vaults:
+  - name: mi
+    url: https://mi.vault.azure.net
+    credentials:
+      type: managed-identity
+      client_id: 00000000-0000-0000-0000-000000000001
+      resource_id: /subscriptions/.../identity
+  - name: sp
+    url: https://sp.vault.azure.net
+    credentials:
+      type: client-secret
+      client_id: app
+  - name: cert
+    url: https://cert.vault.azure.net
+    credentials:
+      type: client-certificate
+      tenant_id: tenant
+      client_id: app
+      token_file: /var/run/token
+`), "-credential", "workload-identity", "-token-file", "/var/run/token", "/mnt"})
+	require.NoError(t, err)
+	assert.Equal(t, credentialConfig{Type: "workload-identity", TokenFile: "/var/run/token"}, config.Credentials)
+
+	err = config.validate()
+	require.Error(t, err)
+	for _, problem := range []string{
+		"vaults[0].credentials: client_id and resource_id cannot both be set",
+		`vaults[1].credentials: credential type "client-secret" needs tenant_id and client_id`,
+		"needs client_secret_file or AZURE_CLIENT_SECRET",
+		`vaults[2].credentials.token_file is not used by credential type "client-certificate"`,
+		`vaults[2].credentials: credential type "client-certificate" needs certificate`,
+	} {
+		assert.Contains(t, err.Error(), problem)
+	}
+	assert.NotContains(t, err.Error(), "credentials.type", "the top-level credentials are valid")
+}
+
+func writeClientCertificate(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "azkv client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyPEM, err := marshalPrivateKeyPEM(key)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "client.pem")
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM...)
+	require.NoError(t, os.WriteFile(file, data, 0600))
+	return file
+}
+
+func Test_newCredential(t *testing.T) {
+	credentials := credentialConfig{
+		Type:        "client-certificate",
+		TenantID:    "tenant",
+		ClientID:    "app",
+		Certificate: writeClientCertificate(t),
+	}
+	credential, err := newCredential(credentials, cloudConfig{}, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &describedCredential{}, credential)
+
+	credentials.Certificate = filepath.Join(t.TempDir(), "missing.pem")
+	_, err = newCredential(credentials, cloudConfig{}, nil)
+	assert.ErrorContains(t, err, "could not read the client certificate")
+
+	_, err = newCredential(credentialConfig{Type: "managed-identity", ClientID: "app"}, cloudConfig{}, nil)
+	assert.NoError(t, err)
+}
+
+type failingCredential struct {
+	err error
+}
+
+func (credential failingCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{}, credential.err
+}
+
+func Test_describedCredential(t *testing.T) {
+	credentials := credentialConfig{Type: "client-secret", TenantID: "tenant", ClientID: "app"}
+	credential := &describedCredential{
+		credential:  failingCredential{err: &azidentity.AuthenticationFailedError{}},
+		description: credentials.describe(),
+	}
+	_, err := credential.GetToken(context.Background(), policy.TokenRequestOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "client-secret (tenant tenant, client app) credential failed")
+	assert.Equal(t, syscall.EACCES, errnoFor(err), "authentication errors are still recognized")
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/pkg/errors"
+)
+
+// emulatorRetention is how long soft-deleted objects are kept, which only
+// affects the scheduledPurgeDate reported by the emulator.
+const emulatorRetention = 90 * 24 * time.Hour
+
+// emulatorIdentityHeader is the secret clients have to send to the token
+// endpoint, like App Service's IDENTITY_HEADER.
+const emulatorIdentityHeader = "emulator"
+
+// emulatedVersion is one version of an object. object holds an
+// azsecrets.Secret, *emulatedKey or azcertificates.Certificate.
+type emulatedVersion struct {
+	version string
+	object  any
+}
+
+type emulatedObject struct {
+	versions []*emulatedVersion
+	// deletedDate is set while the object is soft deleted.
+	deletedDate *time.Time
+
+	// Certificates only
+	policy    *azcertificates.CertificatePolicy
+	operation *azcertificates.CertificateOperation
+}
+
+func (object *emulatedObject) current() *emulatedVersion {
+	return object.versions[len(object.versions)-1]
+}
+
+func (object *emulatedObject) find(version string) *emulatedVersion {
+	if version == "" {
+		return object.current()
+	}
+	for _, v := range object.versions {
+		if v.version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+// KeyVaultEmulator serves an in-memory subset of the Key Vault data-plane
+// REST API: secrets, keys and certificates with versions, pagination and
+// soft delete, plus a managed identity style token endpoint at /msi/token.
+// It is meant for integration tests and demos of the real SDK clients.
+type KeyVaultEmulator struct {
+	mutex    sync.Mutex
+	objects  map[objectKind]map[string]*emulatedObject
+	token    string
+	pageSize int
+	now      func() time.Time
+}
+
+// NewKeyVaultEmulator creates an empty emulated vault returning at most
+// pageSize objects per page.
+func NewKeyVaultEmulator(pageSize int) *KeyVaultEmulator {
+	if pageSize < 1 {
+		pageSize = 25
+	}
+	return &KeyVaultEmulator{
+		objects: map[objectKind]map[string]*emulatedObject{
+			certificateKind: {},
+			keyKind:         {},
+			secretKind:      {},
+		},
+		token:    "emulator-" + newLocalVersion(),
+		pageSize: pageSize,
+		now: func() time.Time {
+			return time.Now().UTC().Truncate(time.Second)
+		},
+	}
+}
+
+var (
+	_ http.Handler = (*KeyVaultEmulator)(nil)
+)
+
+type emulatorError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *emulatorError) Error() string {
+	return e.message
+}
+
+func emulatorErrorf(status int, code string, format string, args ...any) *emulatorError {
+	return &emulatorError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(kind objectKind, name string) *emulatorError {
+	title := strings.ToUpper(kind.String()[:1]) + kind.String()[1:]
+	return emulatorErrorf(http.StatusNotFound, title+"NotFound",
+		"A %s with (name/id) %s was not found in this key vault.", kind, name)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		writeEmulatorError(w, emulatorErrorf(http.StatusInternalServerError, "InternalError", "%v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+func writeEmulatorError(w http.ResponseWriter, err *emulatorError) {
+	data, _ := json.Marshal(map[string]any{
+		"error": map[string]string{
+			"code":    err.code,
+			"message": err.message,
+		},
+	})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(err.status)
+	_, _ = w.Write(data)
+}
+
+// toMap converts an SDK model to a map, so fields the model does not have,
+// like recoveryId, can be added.
+func toMap(value any) map[string]any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return map[string]any{}
+	}
+	result := map[string]any{}
+	_ = json.Unmarshal(data, &result)
+	return result
+}
+
+// emulatorRoute serves requests below a top-level path of the emulator.
+type emulatorRoute func(e *KeyVaultEmulator, r *http.Request) (int, any, *emulatorError)
+
+// emulatorRoutes are the endpoints beyond secrets, keys and certificates,
+// added with registerEmulatorRoute.
+var emulatorRoutes = map[string]emulatorRoute{}
+
+func registerEmulatorRoute(path string, route emulatorRoute) {
+	emulatorRoutes[path] = route
+}
+
+func (e *KeyVaultEmulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if segments[0] == "msi" {
+		e.serveToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+e.token {
+		// Same challenge as Key Vault, which the SDK answers with a token
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer authorization="https://login.microsoftonline.com/%s", resource="https://vault.azure.net"`,
+			emulatorIdentityHeader))
+		writeEmulatorError(w, emulatorErrorf(http.StatusUnauthorized, "Unauthorized",
+			"AKV10000: Request is missing a Bearer or PoP token."))
+		return
+	}
+	log.Println("Emulator:", r.Method, r.URL.Path)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var status int
+	var result any
+	var err *emulatorError
+	switch segments[0] {
+	case "secrets", "keys", "certificates":
+		kind := kindForCollection(segments[0])
+		status, result, err = e.serveObjects(r, kind, segments[1:])
+	case "deletedsecrets", "deletedkeys", "deletedcertificates":
+		kind := kindForCollection(strings.TrimPrefix(segments[0], "deleted"))
+		status, result, err = e.serveDeleted(r, kind, segments[1:])
+	default:
+		if route, ok := emulatorRoutes[segments[0]]; ok {
+			status, result, err = route(e, r)
+		} else {
+			err = emulatorErrorf(http.StatusNotFound, "NotFound", "%s is not supported by the emulator", r.URL.Path)
+		}
+	}
+	if err != nil {
+		writeEmulatorError(w, err)
+		return
+	}
+	if result == nil {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, result)
+}
+
+func kindForCollection(collection string) objectKind {
+	switch collection {
+	case certificatesDirName:
+		return certificateKind
+	case keysDirName:
+		return keyKind
+	case secretsDirName:
+		return secretKind
+	default:
+		return 0
+	}
+}
+
+// serveToken implements the App Service managed identity protocol, which
+// azidentity uses when IDENTITY_ENDPOINT and IDENTITY_HEADER are set.
+func (e *KeyVaultEmulator) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-IDENTITY-HEADER") != emulatorIdentityHeader {
+		writeEmulatorError(w, emulatorErrorf(http.StatusUnauthorized, "Unauthorized",
+			"X-IDENTITY-HEADER must be %q", emulatorIdentityHeader))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": e.token,
+		"expires_on":   strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10),
+		"resource":     r.URL.Query().Get("resource"),
+		"token_type":   "Bearer",
+		"client_id":    "emulator",
+	})
+}
+
+// page returns one page of items and the link to the next one, if any.
+func (e *KeyVaultEmulator) page(r *http.Request, items []any) map[string]any {
+	size := e.pageSize
+	if maxResults, err := strconv.Atoi(r.URL.Query().Get("maxresults")); err == nil && maxResults > 0 && maxResults < size {
+		size = maxResults
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("$skiptoken"))
+	if offset < 0 || offset > len(items) {
+		offset = len(items)
+	}
+	end := offset + size
+	if end > len(items) {
+		end = len(items)
+	}
+	result := map[string]any{"value": items[offset:end]}
+	if end < len(items) {
+		query := r.URL.Query()
+		query.Set("$skiptoken", strconv.Itoa(end))
+		query.Set("maxresults", strconv.Itoa(size))
+		result["nextLink"] = fmt.Sprintf("https://%s%s?%s", r.Host, r.URL.Path, query.Encode())
+	} else {
+		result["nextLink"] = nil
+	}
+	return result
+}
+
+func sortedNames(objects map[string]*emulatedObject) []string {
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *KeyVaultEmulator) objectID(r *http.Request, kind objectKind, name string, version string) string {
+	id := fmt.Sprintf("https://%s/%s/%s", r.Host, kind.dirName(), name)
+	if version != "" {
+		id += "/" + version
+	}
+	return id
+}
+
+// lookup returns an object that is not deleted.
+func (e *KeyVaultEmulator) lookup(kind objectKind, name string) (*emulatedObject, *emulatorError) {
+	object := e.objects[kind][name]
+	if object == nil || object.deletedDate != nil {
+		return nil, notFound(kind, name)
+	}
+	return object, nil
+}
+
+// checkWritable fails if name refers to a soft-deleted object, like Key
+// Vault does until it is purged or recovered.
+func (e *KeyVaultEmulator) checkWritable(kind objectKind, name string) *emulatorError {
+	if object := e.objects[kind][name]; object != nil && object.deletedDate != nil {
+		return emulatorErrorf(http.StatusConflict, "Conflict",
+			"%s %s is currently in a deleted but recoverable state, and its name cannot be reused; "+
+				"in this state, the %s can only be recovered or purged.", kind, name, kind)
+	}
+	return nil
+}
+
+func (e *KeyVaultEmulator) addVersion(kind objectKind, name string, version *emulatedVersion) *emulatedObject {
+	object := e.objects[kind][name]
+	if object == nil {
+		object = &emulatedObject{}
+		e.objects[kind][name] = object
+	}
+	object.versions = append(object.versions, version)
+	return object
+}
+
+func (e *KeyVaultEmulator) serveObjects(r *http.Request, kind objectKind, path []string) (int, any, *emulatorError) {
+	switch {
+	case len(path) == 0 && r.Method == http.MethodGet:
+		var items []any
+		for _, name := range sortedNames(e.objects[kind]) {
+			object := e.objects[kind][name]
+			if object.deletedDate == nil {
+				items = append(items, e.properties(kind, object.current()))
+			}
+		}
+		return http.StatusOK, e.page(r, items), nil
+	case len(path) == 1:
+		name := path[0]
+		switch r.Method {
+		case http.MethodGet:
+			return e.get(kind, name, "")
+		case http.MethodPut:
+			if err := e.checkWritable(kind, name); err != nil {
+				return 0, nil, err
+			}
+			switch kind {
+			case secretKind:
+				return e.setSecret(r, name)
+			case keyKind:
+				return e.importKey(r, name)
+			}
+		case http.MethodDelete:
+			return e.delete(r, kind, name)
+		}
+	case len(path) == 2:
+		name, operation := path[0], path[1]
+		switch {
+		case operation == "versions" && r.Method == http.MethodGet:
+			object, err := e.lookup(kind, name)
+			if err != nil {
+				return 0, nil, err
+			}
+			var items []any
+			for _, version := range object.versions {
+				items = append(items, e.properties(kind, version))
+			}
+			return http.StatusOK, e.page(r, items), nil
+		case operation == "create" && r.Method == http.MethodPost && kind == keyKind:
+			if err := e.checkWritable(kind, name); err != nil {
+				return 0, nil, err
+			}
+			return e.createKey(r, name)
+		case operation == "create" && r.Method == http.MethodPost && kind == certificateKind:
+			if err := e.checkWritable(kind, name); err != nil {
+				return 0, nil, err
+			}
+			return e.createCertificate(r, name)
+		case operation == "import" && r.Method == http.MethodPost && kind == certificateKind:
+			if err := e.checkWritable(kind, name); err != nil {
+				return 0, nil, err
+			}
+			return e.importCertificate(r, name)
+		case operation == "pending" && r.Method == http.MethodGet && kind == certificateKind:
+			object, err := e.lookup(kind, name)
+			if err != nil {
+				return 0, nil, err
+			}
+			if object.operation == nil {
+				return 0, nil, emulatorErrorf(http.StatusNotFound, "PendingCertificateNotFound",
+					"Pending certificate not found: %s", name)
+			}
+			return http.StatusOK, object.operation, nil
+		case operation == "policy" && r.Method == http.MethodGet && kind == certificateKind:
+			object, err := e.lookup(kind, name)
+			if err != nil {
+				return 0, nil, err
+			}
+			return http.StatusOK, object.policy, nil
+		case r.Method == http.MethodGet:
+			return e.get(kind, name, operation)
+		}
+	}
+	return 0, nil, emulatorErrorf(http.StatusMethodNotAllowed, "BadRequest",
+		"%s %s is not supported by the emulator", r.Method, r.URL.Path)
+}
+
+func (e *KeyVaultEmulator) get(kind objectKind, name string, version string) (int, any, *emulatorError) {
+	object, err := e.lookup(kind, name)
+	if err != nil {
+		return 0, nil, err
+	}
+	found := object.find(version)
+	if found == nil {
+		return 0, nil, notFound(kind, name+"/"+version)
+	}
+	if !e.enabled(kind, found) {
+		return 0, nil, emulatorErrorf(http.StatusForbidden, "Forbidden",
+			"Operation get is not allowed on a disabled %s.", kind)
+	}
+	return http.StatusOK, e.bundle(kind, found), nil
+}
+
+func (e *KeyVaultEmulator) delete(r *http.Request, kind objectKind, name string) (int, any, *emulatorError) {
+	object, err := e.lookup(kind, name)
+	if err != nil {
+		return 0, nil, err
+	}
+	now := e.now()
+	object.deletedDate = &now
+	if kind == certificateKind {
+		// The managed key and secret go along with the certificate
+		for _, backing := range []objectKind{keyKind, secretKind} {
+			if object := e.objects[backing][name]; object != nil && object.deletedDate == nil {
+				object.deletedDate = &now
+			}
+		}
+	}
+	return http.StatusOK, e.deleted(r, kind, name, object), nil
+}
+
+// deleted renders a soft-deleted object.
+func (e *KeyVaultEmulator) deleted(r *http.Request, kind objectKind, name string, object *emulatedObject) map[string]any {
+	result := toMap(e.bundle(kind, object.current()))
+	result["recoveryId"] = fmt.Sprintf("https://%s/deleted%s/%s", r.Host, kind.dirName(), name)
+	result["deletedDate"] = object.deletedDate.Unix()
+	result["scheduledPurgeDate"] = object.deletedDate.Add(emulatorRetention).Unix()
+	return result
+}
+
+func (e *KeyVaultEmulator) serveDeleted(r *http.Request, kind objectKind, path []string) (int, any, *emulatorError) {
+	if len(path) == 0 && r.Method == http.MethodGet {
+		var items []any
+		for _, name := range sortedNames(e.objects[kind]) {
+			if object := e.objects[kind][name]; object.deletedDate != nil {
+				items = append(items, e.deleted(r, kind, name, object))
+			}
+		}
+		return http.StatusOK, e.page(r, items), nil
+	}
+	if len(path) == 0 || len(path) > 2 {
+		return 0, nil, emulatorErrorf(http.StatusNotFound, "NotFound", "%s not found", r.URL.Path)
+	}
+
+	name := path[0]
+	object := e.objects[kind][name]
+	if object == nil || object.deletedDate == nil {
+		return 0, nil, notFound(kind, name)
+	}
+	related := []objectKind{kind}
+	if kind == certificateKind {
+		related = append(related, keyKind, secretKind)
+	}
+
+	switch {
+	case len(path) == 1 && r.Method == http.MethodGet:
+		return http.StatusOK, e.deleted(r, kind, name, object), nil
+	case len(path) == 1 && r.Method == http.MethodDelete:
+		for _, relatedKind := range related {
+			delete(e.objects[relatedKind], name)
+		}
+		return http.StatusNoContent, nil, nil
+	case len(path) == 2 && path[1] == "recover" && r.Method == http.MethodPost:
+		for _, relatedKind := range related {
+			if object := e.objects[relatedKind][name]; object != nil {
+				object.deletedDate = nil
+			}
+		}
+		return http.StatusOK, e.bundle(kind, object.current()), nil
+	}
+	return 0, nil, emulatorErrorf(http.StatusMethodNotAllowed, "BadRequest",
+		"%s %s is not supported by the emulator", r.Method, r.URL.Path)
+}
+
+// generateEmulatorCertificate creates the self-signed TLS certificate the
+// emulator serves, valid for the loopback addresses and host.
+func generateEmulatorCertificate(host string) (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "Key Vault emulator"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if host != "" && host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certificatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM, err := marshalPrivateKeyPEM(key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certificate, err := tls.X509KeyPair(certificatePEM, keyPEM)
+	return certificate, certificatePEM, err
+}
+
+// runEmulator implements the emulator subcommand.
+func runEmulator(args []string) {
+	flags := flag.NewFlagSet("emulator", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:8443", "Address to serve the emulated Key Vault on")
+	certFile := flags.String("tls-cert", "", "TLS certificate to serve; a self-signed one is generated if empty")
+	keyFile := flags.String("tls-key", "", "Private key of -tls-cert")
+	certOut := flags.String("write-cert", filepath.Join(os.TempDir(), "azkv-emulator.crt"),
+		"Where to write the generated certificate, for clients to trust via SSL_CERT_FILE")
+	pageSize := flags.Int("page-size", 25, "Maximum number of objects per page in listings")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s emulator [options...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	host, port, err := net.SplitHostPort(*listen)
+	if err != nil {
+		log.Fatal(errors.Wrapf(err, "invalid listen address %q", *listen))
+	}
+
+	var certificate tls.Certificate
+	if len(*certFile) != 0 {
+		certificate, err = tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "could not load TLS certificate"))
+		}
+	} else {
+		var certificatePEM []byte
+		certificate, certificatePEM, err = generateEmulatorCertificate(host)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "could not generate TLS certificate"))
+		}
+		if err := os.WriteFile(*certOut, certificatePEM, 0644); err != nil {
+			log.Fatal(errors.Wrap(err, "could not write TLS certificate"))
+		}
+		log.Println("Wrote emulator certificate to", *certOut)
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+	vaultURL := "https://" + net.JoinHostPort(host, port)
+	log.Println("Serving emulated Key Vault on", vaultURL)
+	log.Println("Point the mount at it with:")
+	if len(*certFile) == 0 {
+		log.Printf("  export SSL_CERT_FILE=%s", *certOut)
+	}
+	log.Printf("  export IDENTITY_ENDPOINT=%s/msi/token IDENTITY_HEADER=%s", vaultURL, emulatorIdentityHeader)
+	log.Printf("  %s -url %s <mount point>", os.Args[0], vaultURL)
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           NewKeyVaultEmulator(*pageSize),
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	log.Fatal(server.ListenAndServeTLS("", ""))
+}
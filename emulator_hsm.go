@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+func init() {
+	registerEmulatorRoute("rng", serveRandom)
+}
+
+// serveRandom implements the Managed HSM GetRandomBytes operation.
+func serveRandom(e *KeyVaultEmulator, r *http.Request) (int, any, *emulatorError) {
+	if r.Method != http.MethodPost {
+		return 0, nil, emulatorErrorf(http.StatusMethodNotAllowed, "BadRequest", "use POST")
+	}
+	var parameters struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&parameters); err != nil || parameters.Count < 1 || parameters.Count > 128 {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "count must be between 1 and 128")
+	}
+	data := make([]byte, parameters.Count)
+	_, _ = rand.Read(data)
+	return http.StatusOK, map[string]string{"value": base64.RawURLEncoding.EncodeToString(data)}, nil
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// emulatedKey is a version of a key. Only bundle, without private parts, is
+// ever returned to clients.
+type emulatedKey struct {
+	bundle  azkeys.KeyBundle
+	private *azkeys.JSONWebKey
+}
+
+func decodeParameters(r *http.Request, parameters any) *emulatorError {
+	if err := json.NewDecoder(r.Body).Decode(parameters); err != nil {
+		return emulatorErrorf(http.StatusBadRequest, "BadParameter", "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func (e *KeyVaultEmulator) enabled(kind objectKind, version *emulatedVersion) bool {
+	var enabled *bool
+	switch object := version.object.(type) {
+	case azsecrets.Secret:
+		if object.Attributes != nil {
+			enabled = object.Attributes.Enabled
+		}
+	case *emulatedKey:
+		if object.bundle.Attributes != nil {
+			enabled = object.bundle.Attributes.Enabled
+		}
+	case azcertificates.Certificate:
+		if object.Attributes != nil {
+			enabled = object.Attributes.Enabled
+		}
+	}
+	return enabled == nil || *enabled
+}
+
+// bundle returns what a GET of the version responds with.
+func (e *KeyVaultEmulator) bundle(kind objectKind, version *emulatedVersion) any {
+	switch object := version.object.(type) {
+	case *emulatedKey:
+		return object.bundle
+	default:
+		return object
+	}
+}
+
+// properties returns the version as it appears in listings.
+func (e *KeyVaultEmulator) properties(kind objectKind, version *emulatedVersion) any {
+	switch object := version.object.(type) {
+	case azsecrets.Secret:
+		return azsecrets.SecretProperties{
+			Attributes:  object.Attributes,
+			ContentType: object.ContentType,
+			ID:          object.ID,
+			Tags:        object.Tags,
+			Managed:     object.Managed,
+		}
+	case *emulatedKey:
+		return azkeys.KeyProperties{
+			Attributes: object.bundle.Attributes,
+			KID:        object.bundle.Key.KID,
+			Tags:       object.bundle.Tags,
+			Managed:    object.bundle.Managed,
+		}
+	case azcertificates.Certificate:
+		return azcertificates.CertificateProperties{
+			Attributes:     object.Attributes,
+			ID:             object.ID,
+			Tags:           object.Tags,
+			X509Thumbprint: object.X509Thumbprint,
+		}
+	default:
+		return nil
+	}
+}
+
+func (e *KeyVaultEmulator) setSecret(r *http.Request, name string) (int, any, *emulatorError) {
+	var parameters azsecrets.SetSecretParameters
+	if err := decodeParameters(r, &parameters); err != nil {
+		return 0, nil, err
+	}
+	if parameters.Value == nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "value is required")
+	}
+	if object := e.objects[secretKind][name]; object != nil {
+		if secret := object.current().object.(azsecrets.Secret); secret.Managed != nil && *secret.Managed {
+			return 0, nil, emulatorErrorf(http.StatusConflict, "Conflict",
+				"Secret %s is managed by certificate %s and cannot be set.", name, name)
+		}
+	}
+	version := newLocalVersion()
+	secret := azsecrets.Secret{
+		Attributes:  e.secretAttributes(parameters.SecretAttributes),
+		ContentType: parameters.ContentType,
+		ID:          (*azsecrets.ID)(stringPointer(e.objectID(r, secretKind, name, version))),
+		Tags:        parameters.Tags,
+		Value:       parameters.Value,
+	}
+	e.addVersion(secretKind, name, &emulatedVersion{version: version, object: secret})
+	return http.StatusOK, secret, nil
+}
+
+func stringPointer(value string) *string {
+	return &value
+}
+
+func (e *KeyVaultEmulator) secretAttributes(requested *azsecrets.SecretAttributes) *azsecrets.SecretAttributes {
+	now := e.now()
+	enabled := true
+	attributes := &azsecrets.SecretAttributes{Enabled: &enabled, Created: &now, Updated: &now}
+	if requested != nil {
+		if requested.Enabled != nil {
+			attributes.Enabled = requested.Enabled
+		}
+		attributes.Expires = requested.Expires
+		attributes.NotBefore = requested.NotBefore
+	}
+	return attributes
+}
+
+func (e *KeyVaultEmulator) keyAttributes(requested *azkeys.KeyAttributes) *azkeys.KeyAttributes {
+	now := e.now()
+	enabled := true
+	attributes := &azkeys.KeyAttributes{Enabled: &enabled, Created: &now, Updated: &now}
+	if requested != nil {
+		if requested.Enabled != nil {
+			attributes.Enabled = requested.Enabled
+		}
+		attributes.Expires = requested.Expires
+		attributes.NotBefore = requested.NotBefore
+		attributes.Exportable = requested.Exportable
+	}
+	return attributes
+}
+
+// storeKey adds a version of a key from its private JSON web key.
+func (e *KeyVaultEmulator) storeKey(r *http.Request, name string, version string, private *azkeys.JSONWebKey,
+	attributes *azkeys.KeyAttributes, tags map[string]*string, managed bool) azkeys.KeyBundle {
+	private.KID = (*azkeys.ID)(stringPointer(e.objectID(r, keyKind, name, version)))
+	if len(private.KeyOps) == 0 {
+		private.KeyOps = defaultKeyOperations(*private.Kty)
+	}
+	key := &emulatedKey{
+		bundle: azkeys.KeyBundle{
+			Attributes: attributes,
+			Key:        publicJSONWebKey(private),
+			Tags:       tags,
+		},
+		private: private,
+	}
+	if managed {
+		key.bundle.Managed = &managed
+	}
+	e.addVersion(keyKind, name, &emulatedVersion{version: version, object: key})
+	return key.bundle
+}
+
+func defaultKeyOperations(kty azkeys.KeyType) []*azkeys.KeyOperation {
+	var operations []azkeys.KeyOperation
+	switch kty {
+	case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+		operations = []azkeys.KeyOperation{azkeys.KeyOperationEncrypt, azkeys.KeyOperationDecrypt,
+			azkeys.KeyOperationSign, azkeys.KeyOperationVerify, azkeys.KeyOperationWrapKey, azkeys.KeyOperationUnwrapKey}
+	case azkeys.KeyTypeEC, azkeys.KeyTypeECHSM:
+		operations = []azkeys.KeyOperation{azkeys.KeyOperationSign, azkeys.KeyOperationVerify}
+	default:
+		operations = []azkeys.KeyOperation{azkeys.KeyOperationEncrypt, azkeys.KeyOperationDecrypt,
+			azkeys.KeyOperationWrapKey, azkeys.KeyOperationUnwrapKey}
+	}
+	result := make([]*azkeys.KeyOperation, len(operations))
+	for i := range operations {
+		result[i] = &operations[i]
+	}
+	return result
+}
+
+func (e *KeyVaultEmulator) importKey(r *http.Request, name string) (int, any, *emulatorError) {
+	var parameters azkeys.ImportKeyParameters
+	if err := decodeParameters(r, &parameters); err != nil {
+		return 0, nil, err
+	}
+	if parameters.Key == nil || parameters.Key.Kty == nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "key with kty is required")
+	}
+	bundle := e.storeKey(r, name, newLocalVersion(), parameters.Key,
+		e.keyAttributes(parameters.KeyAttributes), parameters.Tags, false)
+	return http.StatusOK, bundle, nil
+}
+
+// generateKey creates the private key described by a key type, size and
+// curve, with Key Vault's defaults for missing values.
+func generateKey(kty azkeys.KeyType, size *int32, curve *azkeys.CurveName) (*azkeys.JSONWebKey, *emulatorError) {
+	switch kty {
+	case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+		bits := 2048
+		if size != nil {
+			bits = int(*size)
+		}
+		if bits != 2048 && bits != 3072 && bits != 4096 {
+			return nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "unsupported RSA key size %d", bits)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, emulatorErrorf(http.StatusInternalServerError, "InternalError", "%v", err)
+		}
+		jwk, _ := jsonWebKeyFromPrivate(key)
+		jwk.Kty = &kty
+		return jwk, nil
+	case azkeys.KeyTypeEC, azkeys.KeyTypeECHSM:
+		crv := azkeys.CurveNameP256
+		if curve != nil {
+			crv = *curve
+		}
+		var ellipticCurve elliptic.Curve
+		for c, name := range curveNames {
+			if name == crv {
+				ellipticCurve = c
+			}
+		}
+		if ellipticCurve == nil {
+			return nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "unsupported curve %s", crv)
+		}
+		key, err := ecdsa.GenerateKey(ellipticCurve, rand.Reader)
+		if err != nil {
+			return nil, emulatorErrorf(http.StatusInternalServerError, "InternalError", "%v", err)
+		}
+		jwk, _ := jsonWebKeyFromPrivate(key)
+		jwk.Kty = &kty
+		return jwk, nil
+	case azkeys.KeyTypeOct, azkeys.KeyTypeOctHSM:
+		bits := 256
+		if size != nil {
+			bits = int(*size)
+		}
+		if bits != 128 && bits != 192 && bits != 256 {
+			return nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "unsupported oct key size %d", bits)
+		}
+		k := make([]byte, bits/8)
+		_, _ = rand.Read(k)
+		return &azkeys.JSONWebKey{Kty: &kty, K: k}, nil
+	default:
+		return nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "unsupported key type %s", kty)
+	}
+}
+
+func (e *KeyVaultEmulator) createKey(r *http.Request, name string) (int, any, *emulatorError) {
+	var parameters azkeys.CreateKeyParameters
+	if err := decodeParameters(r, &parameters); err != nil {
+		return 0, nil, err
+	}
+	if parameters.Kty == nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "kty is required")
+	}
+	private, err := generateKey(*parameters.Kty, parameters.KeySize, parameters.Curve)
+	if err != nil {
+		return 0, nil, err
+	}
+	private.KeyOps = parameters.KeyOps
+	bundle := e.storeKey(r, name, newLocalVersion(), private,
+		e.keyAttributes(parameters.KeyAttributes), parameters.Tags, false)
+	return http.StatusOK, bundle, nil
+}
+
+// parseDistinguishedName parses subjects like "CN=example.com, O=Example"
+// as used in certificate policies.
+func parseDistinguishedName(subject string) pkix.Name {
+	var name pkix.Name
+	for _, part := range strings.Split(subject, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToUpper(strings.TrimSpace(key)) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "ST", "S":
+			name.Province = append(name.Province, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		}
+	}
+	return name
+}
+
+// storeCertificate adds a version of a certificate along with its managed
+// key and secret, which share the version like in Key Vault.
+func (e *KeyVaultEmulator) storeCertificate(r *http.Request, name string, bundle *certificateBundle,
+	policy *azcertificates.CertificatePolicy, attributes *azcertificates.CertificateAttributes,
+	tags map[string]*string) (azcertificates.Certificate, *emulatorError) {
+	contentType := pkcs12ContentType
+	if policy != nil && policy.SecretProperties != nil && policy.SecretProperties.ContentType != nil {
+		contentType = *policy.SecretProperties.ContentType
+	}
+	var value string
+	switch contentType {
+	case pkcs12ContentType:
+		pfx, err := pkcs12.Modern.Encode(bundle.key, bundle.leaf, bundle.chain, "")
+		if err != nil {
+			return azcertificates.Certificate{}, emulatorErrorf(http.StatusBadRequest, "BadParameter", "%v", err)
+		}
+		value = base64.StdEncoding.EncodeToString(pfx)
+	case pemContentType:
+		keyPEM, err := marshalPrivateKeyPEM(bundle.key)
+		if err != nil {
+			return azcertificates.Certificate{}, emulatorErrorf(http.StatusBadRequest, "BadParameter", "%v", err)
+		}
+		value = string(keyPEM)
+		for _, certificate := range append([]*x509.Certificate{bundle.leaf}, bundle.chain...) {
+			value += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}))
+		}
+	default:
+		return azcertificates.Certificate{}, emulatorErrorf(http.StatusBadRequest, "BadParameter",
+			"unsupported content type %s", contentType)
+	}
+	private, err := jsonWebKeyFromPrivate(bundle.key)
+	if err != nil {
+		return azcertificates.Certificate{}, emulatorErrorf(http.StatusBadRequest, "BadParameter", "%v", err)
+	}
+
+	now := e.now()
+	enabled := true
+	if attributes == nil {
+		attributes = &azcertificates.CertificateAttributes{}
+	}
+	if attributes.Enabled != nil {
+		enabled = *attributes.Enabled
+	}
+	version := newLocalVersion()
+	managed := true
+	secret := azsecrets.Secret{
+		Attributes: &azsecrets.SecretAttributes{
+			Enabled: &enabled, Created: &now, Updated: &now,
+			NotBefore: &bundle.leaf.NotBefore, Expires: &bundle.leaf.NotAfter,
+		},
+		ContentType: &contentType,
+		ID:          (*azsecrets.ID)(stringPointer(e.objectID(r, secretKind, name, version))),
+		KID:         (*azsecrets.ID)(stringPointer(e.objectID(r, keyKind, name, version))),
+		Managed:     &managed,
+		Value:       &value,
+	}
+	e.addVersion(secretKind, name, &emulatedVersion{version: version, object: secret})
+	e.storeKey(r, name, version, private, &azkeys.KeyAttributes{
+		Enabled: &enabled, Created: &now, Updated: &now,
+		NotBefore: &bundle.leaf.NotBefore, Expires: &bundle.leaf.NotAfter,
+	}, nil, true)
+
+	thumbprint := sha1.Sum(bundle.leaf.Raw)
+	certificate := azcertificates.Certificate{
+		Attributes: &azcertificates.CertificateAttributes{
+			Enabled: &enabled, Created: &now, Updated: &now,
+			NotBefore: &bundle.leaf.NotBefore, Expires: &bundle.leaf.NotAfter,
+		},
+		CER:            bundle.leaf.Raw,
+		ContentType:    &contentType,
+		ID:             (*azcertificates.ID)(stringPointer(e.objectID(r, certificateKind, name, version))),
+		KID:            (*azcertificates.ID)(stringPointer(e.objectID(r, keyKind, name, version))),
+		SID:            (*azcertificates.ID)(stringPointer(e.objectID(r, secretKind, name, version))),
+		Policy:         policy,
+		Tags:           tags,
+		X509Thumbprint: thumbprint[:],
+	}
+	object := e.addVersion(certificateKind, name, &emulatedVersion{version: version, object: certificate})
+	object.policy = policy
+	return certificate, nil
+}
+
+func (e *KeyVaultEmulator) importCertificate(r *http.Request, name string) (int, any, *emulatorError) {
+	var parameters azcertificates.ImportCertificateParameters
+	if err := decodeParameters(r, &parameters); err != nil {
+		return 0, nil, err
+	}
+	if parameters.Base64EncodedCertificate == nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "value is required")
+	}
+	password := ""
+	if parameters.Password != nil {
+		password = *parameters.Password
+	}
+	bundle, err := parseCertificateBundle([]byte(*parameters.Base64EncodedCertificate), password)
+	if err != nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "%v", err)
+	}
+	if bundle.key == nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter",
+			"The specified certificate has no private key.")
+	}
+	policy := parameters.CertificatePolicy
+	if policy == nil {
+		policy = &azcertificates.CertificatePolicy{}
+	}
+	if policy.SecretProperties == nil || policy.SecretProperties.ContentType == nil {
+		policy.SecretProperties = &azcertificates.SecretProperties{ContentType: &bundle.contentType}
+	}
+	certificate, emulatorErr := e.storeCertificate(r, name, bundle, policy, parameters.CertificateAttributes, parameters.Tags)
+	if emulatorErr != nil {
+		return 0, nil, emulatorErr
+	}
+	return http.StatusOK, certificate, nil
+}
+
+// createCertificate supports self-signed certificates only, which are
+// issued right away; the returned operation is already completed.
+func (e *KeyVaultEmulator) createCertificate(r *http.Request, name string) (int, any, *emulatorError) {
+	var parameters azcertificates.CreateCertificateParameters
+	if err := decodeParameters(r, &parameters); err != nil {
+		return 0, nil, err
+	}
+	policy := parameters.CertificatePolicy
+	if policy == nil || policy.X509CertificateProperties == nil || policy.X509CertificateProperties.Subject == nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "policy with a subject is required")
+	}
+	if policy.IssuerParameters != nil && policy.IssuerParameters.Name != nil &&
+		!strings.EqualFold(*policy.IssuerParameters.Name, "Self") {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter",
+			"the emulator only supports the issuer Self")
+	}
+
+	kty := azkeys.KeyTypeRSA
+	var size *int32
+	var curve *azkeys.CurveName
+	if properties := policy.KeyProperties; properties != nil {
+		if properties.KeyType != nil {
+			kty = azkeys.KeyType(*properties.KeyType)
+		}
+		size = properties.KeySize
+		if properties.Curve != nil {
+			crv := azkeys.CurveName(*properties.Curve)
+			curve = &crv
+		}
+	}
+	jwk, emulatorErr := generateKey(kty, size, curve)
+	if emulatorErr != nil {
+		return 0, nil, emulatorErr
+	}
+	key, err := privateKeyFromJSONWebKey(jwk)
+	if err != nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "%v", err)
+	}
+
+	x509Properties := policy.X509CertificateProperties
+	months := 12
+	if x509Properties.ValidityInMonths != nil {
+		months = int(*x509Properties.ValidityInMonths)
+	}
+	now := e.now()
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               parseDistinguishedName(*x509Properties.Subject),
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.AddDate(0, months, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if sans := x509Properties.SubjectAlternativeNames; sans != nil {
+		for _, dnsName := range sans.DNSNames {
+			template.DNSNames = append(template.DNSNames, *dnsName)
+		}
+		for _, email := range sans.Emails {
+			template.EmailAddresses = append(template.EmailAddresses, *email)
+		}
+	}
+	signer := key.(crypto.Signer)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return 0, nil, emulatorErrorf(http.StatusBadRequest, "BadParameter", "%v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+
+	if _, emulatorErr := e.storeCertificate(r, name, &certificateBundle{key: key, leaf: leaf}, policy,
+		parameters.CertificateAttributes, parameters.Tags); emulatorErr != nil {
+		return 0, nil, emulatorErr
+	}
+	issuer := "Self"
+	status := "completed"
+	target := e.objectID(r, certificateKind, name, "")
+	operation := &azcertificates.CertificateOperation{
+		IssuerParameters: &azcertificates.IssuerParameters{Name: &issuer},
+		Status:           &status,
+		Target:           &target,
+		ID:               (*azcertificates.ID)(stringPointer(e.objectID(r, certificateKind, name, "pending"))),
+	}
+	e.objects[certificateKind][name].operation = operation
+	return http.StatusAccepted, operation, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEmulator(t *testing.T, pageSize int) *AzKVClients {
+	return connectTestEmulator(t, pageSize, false)
+}
+
+// connectTestEmulator serves an emulator and connects the real SDK clients
+// to it using the emulator's managed identity endpoint, as to a Managed HSM
+// if managedHSM is set.
+func connectTestEmulator(t *testing.T, pageSize int, managedHSM bool) *AzKVClients {
+	server := httptest.NewTLSServer(NewKeyVaultEmulator(pageSize))
+	t.Cleanup(server.Close)
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_TENANT_ID", "")
+	t.Setenv("IDENTITY_ENDPOINT", server.URL+"/msi/token")
+	t.Setenv("IDENTITY_HEADER", emulatorIdentityHeader)
+
+	return ConnectToKeyVault(server.URL, ConnectOptions{
+		Credentials:                          credentialConfig{Type: "managed-identity"},
+		Transport:                            server.Client(),
+		ManagedHSM:                           managedHSM,
+		DisableChallengeResourceVerification: isLoopbackVault(server.URL),
+	})
+}
+
+func Test_KeyVaultEmulator_secrets(t *testing.T) {
+	ctx := context.Background()
+	clients := newTestEmulator(t, 2)
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		value := "value of " + name
+		_, err := clients.SetSecret(ctx, name, azsecrets.SetSecretParameters{Value: &value})
+		require.NoError(t, err)
+	}
+	updated := "updated"
+	_, err := clients.SetSecret(ctx, "a", azsecrets.SetSecretParameters{Value: &updated})
+	require.NoError(t, err)
+
+	secrets, err := clients.List(ctx, secretKind)
+	require.NoError(t, err)
+	require.Len(t, secrets, 5, "all pages are listed")
+	assert.Equal(t, "e", secrets[4].name)
+
+	secret, err := clients.GetSecret(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", *secret.Value)
+
+	versions := 0
+	pager := clients.secrets.NewListSecretPropertiesVersionsPager("a", nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		require.NoError(t, err)
+		versions += len(page.Value)
+	}
+	assert.Equal(t, 2, versions)
+
+	require.NoError(t, clients.Delete(ctx, secretKind, "a"))
+	_, err = clients.GetSecret(ctx, "a")
+	assert.Equal(t, 404, err.(*azcore.ResponseError).StatusCode)
+	_, err = clients.SetSecret(ctx, "a", azsecrets.SetSecretParameters{Value: &updated})
+	assert.Equal(t, 409, err.(*azcore.ResponseError).StatusCode, "deleted names cannot be reused")
+
+	_, err = clients.secrets.RecoverDeletedSecret(ctx, "a", nil)
+	require.NoError(t, err)
+	secret, err = clients.GetSecret(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", *secret.Value)
+
+	require.NoError(t, clients.Delete(ctx, secretKind, "b"))
+	_, err = clients.secrets.PurgeDeletedSecret(ctx, "b", nil)
+	require.NoError(t, err)
+	_, err = clients.SetSecret(ctx, "b", azsecrets.SetSecretParameters{Value: &updated})
+	assert.NoError(t, err, "purged names can be reused")
+}
+
+func Test_KeyVaultEmulator_keys(t *testing.T) {
+	ctx := context.Background()
+	clients := newTestEmulator(t, 25)
+
+	kty := azkeys.KeyTypeEC
+	crv := azkeys.CurveNameP384
+	created, err := clients.keys.CreateKey(ctx, "signing", azkeys.CreateKeyParameters{Kty: &kty, Curve: &crv}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "signing", created.Key.KID.Name())
+	assert.NotEmpty(t, created.Key.KID.Version())
+	assert.Len(t, created.Key.X, 48)
+	assert.Empty(t, created.Key.D, "private parts are never returned")
+
+	key, err := clients.GetKey(ctx, "signing")
+	require.NoError(t, err)
+	assert.Equal(t, *created.Key.KID, *key.Key.KID)
+}
+
+func Test_KeyVaultEmulator_managedHSM(t *testing.T) {
+	ctx := context.Background()
+	clients := connectTestEmulator(t, 25, true)
+	assert.Nil(t, clients.secrets, "no clients are created for secrets and certificates")
+	assert.Nil(t, clients.certificates)
+
+	kty := azkeys.KeyTypeRSAHSM
+	_, err := clients.keys.CreateKey(ctx, "wrapping", azkeys.CreateKeyParameters{Kty: &kty}, nil)
+	require.NoError(t, err)
+	_, err = clients.List(ctx, secretKind)
+	assert.Equal(t, syscall.EOPNOTSUPP, errnoFor(err))
+
+	bytes, err := clients.keys.GetRandomBytes(ctx, azkeys.GetRandomBytesParameters{Count: to(int32(16))}, nil)
+	require.NoError(t, err)
+	assert.Len(t, bytes.Value, 16)
+
+	root := newRootEntry(clients)
+	require.NoError(t, root.retrieveDirectoryListing(ctx))
+	var names []string
+	for _, child := range root.children {
+		names = append(names, child.name)
+	}
+	assert.Equal(t, []string{"keys", "random"}, names)
+
+	random, err := root.Find("random", ctx)
+	require.NoError(t, err)
+	size, err := random.sizeContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(randomFileSize), size)
+	first, err := random.Download(ctx)
+	require.NoError(t, err)
+	second, err := random.Download(ctx)
+	require.NoError(t, err)
+	assert.Len(t, first, randomFileSize)
+	assert.NotEqual(t, first, second, "every read generates new bytes")
+
+	keys, err := root.Find("keys", ctx)
+	require.NoError(t, err)
+	wrapping, err := keys.Find("wrapping.pem", ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, wrapping)
+}
+
+func Test_KeyVaultEmulator_certificates(t *testing.T) {
+	ctx := context.Background()
+	clients := newTestEmulator(t, 25)
+
+	subject := "CN=example.com"
+	_, err := clients.certificates.CreateCertificate(ctx, "web", azcertificates.CreateCertificateParameters{
+		CertificatePolicy: &azcertificates.CertificatePolicy{
+			IssuerParameters: &azcertificates.IssuerParameters{Name: to("Self")},
+			X509CertificateProperties: &azcertificates.X509CertificateProperties{
+				Subject: &subject,
+				SubjectAlternativeNames: &azcertificates.SubjectAlternativeNames{
+					DNSNames: []*string{to("example.com")},
+				},
+			},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	certificate, err := clients.GetCertificate(ctx, "web")
+	require.NoError(t, err)
+	assert.Equal(t, certificate.ID.Version(), certificate.SID.Version())
+
+	secret, err := clients.GetSecret(ctx, "web")
+	require.NoError(t, err)
+	assert.Equal(t, pkcs12ContentType, *secret.ContentType)
+	bundle, err := parseCertificateBundle([]byte(*secret.Value), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, bundle.leaf.DNSNames)
+	assert.Equal(t, certificate.CER, bundle.leaf.Raw)
+
+	imported, err := clients.ImportCertificate(ctx, "imported", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: to(string(selfSignedPEM(t, "imported.example.com"))),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, pemContentType, *imported.ContentType)
+
+	require.NoError(t, clients.Delete(ctx, certificateKind, "web"))
+	secrets, err := clients.List(ctx, secretKind)
+	require.NoError(t, err)
+	require.Len(t, secrets, 1, "the backing secret is deleted with the certificate")
+	assert.Equal(t, "imported", secrets[0].name)
+}
+
+func to[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/pkg/errors"
+)
+
+// errnoError carries the errno that should be reported to the kernel
+// alongside the original error, so the cause is still available for logging.
+type errnoError struct {
+	err   error
+	errno syscall.Errno
+}
+
+var (
+	_ fuse.ErrorNumber = (*errnoError)(nil)
+)
+
+func (e *errnoError) Error() string {
+	return e.err.Error()
+}
+
+func (e *errnoError) Unwrap() error {
+	return e.err
+}
+
+func (e *errnoError) Errno() fuse.Errno {
+	return fuse.Errno(e.errno)
+}
+
+// credentialUnavailableErrorType is the type of the (unexported) error azidentity
+// returns when a credential cannot attempt authentication at all.
+var credentialUnavailableErrorType = reflect.TypeOf(azidentity.NewCredentialUnavailableError(""))
+
+func isCredentialUnavailable(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if reflect.TypeOf(err) == credentialUnavailableErrorType {
+			return true
+		}
+	}
+	return false
+}
+
+// errnoFor determines the errno that best describes err.
+func errnoFor(err error) syscall.Errno {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	var errnum fuse.ErrorNumber
+	if errors.As(err, &errnum) {
+		return syscall.Errno(errnum.Errno())
+	}
+
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		return syscall.EAGAIN
+	case errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, os.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	case errors.Is(err, context.DeadlineExceeded):
+		return syscall.ETIMEDOUT
+	}
+
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) || isCredentialUnavailable(err) {
+		log.Println("Authentication against Key Vault failed:", err)
+		return syscall.EACCES
+	}
+
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusUnauthorized:
+			log.Println("Key Vault rejected our credentials:", respErr.ErrorCode)
+			return syscall.EACCES
+		case http.StatusForbidden:
+			return syscall.EACCES
+		case http.StatusNotFound:
+			return syscall.ENOENT
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return syscall.EAGAIN
+		}
+	}
+
+	return syscall.EIO
+}
+
+// toFuseError maps errors returned by Key Vault to an error carrying a
+// meaningful errno. Without this, bazil reports every error as EIO.
+func toFuseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	errno := errnoFor(err)
+	if errno == syscall.EIO {
+		log.Println("Key Vault request failed:", err)
+	}
+	return &errnoError{err: err, errno: errno}
+}
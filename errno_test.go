@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_errnoFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want syscall.Errno
+	}{
+		{"forbidden", &azcore.ResponseError{StatusCode: http.StatusForbidden}, syscall.EACCES},
+		{"unauthorized", &azcore.ResponseError{StatusCode: http.StatusUnauthorized}, syscall.EACCES},
+		{"not found", &azcore.ResponseError{StatusCode: http.StatusNotFound}, syscall.ENOENT},
+		{"throttled", &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, syscall.EAGAIN},
+		{"unavailable", &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable}, syscall.EAGAIN},
+		{"server error", &azcore.ResponseError{StatusCode: http.StatusInternalServerError}, syscall.EIO},
+		{"wrapped", errors.Wrap(&azcore.ResponseError{StatusCode: http.StatusNotFound}, "could not get secret"), syscall.ENOENT},
+		{"canceled", errors.Wrap(context.Canceled, "could not get secret"), syscall.EINTR},
+		{"credential unavailable", azidentity.NewCredentialUnavailableError("no credential"), syscall.EACCES},
+		{"auth failed", &azidentity.AuthenticationFailedError{}, syscall.EACCES},
+		{"errno", syscall.EROFS, syscall.EROFS},
+		{"other", errors.New("something else"), syscall.EIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, errnoFor(tt.err))
+		})
+	}
+}
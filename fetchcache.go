@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// fetchCache is a Backend that reuses the objects it fetched for
+// cooldownTime, like listings are reused. The files of a listing share one,
+// so that the many files of a certificate cost a single request for the
+// certificate and one for its secret, however often they are stat'ed and
+// opened.
+type fetchCache struct {
+	Backend
+
+	mutex   sync.Mutex
+	objects map[fetchKey]*fetchedObject
+	// layers are the caches of the vaults of an overlay, see layerOf.
+	layers map[Backend]*fetchCache
+}
+
+type fetchKey struct {
+	kind objectKind
+	name string
+}
+
+// fetchedObject is an object fetched at fetchTime. Its mutex is held while
+// fetching, so that concurrent requests for the object wait for one fetch.
+type fetchedObject struct {
+	mutex     sync.Mutex
+	fetchTime time.Time
+	object    any
+}
+
+var (
+	_ Backend        = (*fetchCache)(nil)
+	_ layeredBackend = (*fetchCache)(nil)
+)
+
+func newFetchCache(backend Backend) *fetchCache {
+	return &fetchCache{
+		Backend: backend,
+		objects: map[fetchKey]*fetchedObject{},
+		layers:  map[Backend]*fetchCache{},
+	}
+}
+
+// cachedFetch returns the object fetched last if it is recent enough, or
+// else fetches it. Failures are not cached.
+func cachedFetch[T any](cache *fetchCache, kind objectKind, name string, fetch func() (T, error)) (T, error) {
+	key := fetchKey{kind: kind, name: name}
+	cache.mutex.Lock()
+	fetched, ok := cache.objects[key]
+	if !ok {
+		fetched = &fetchedObject{}
+		cache.objects[key] = fetched
+	}
+	cache.mutex.Unlock()
+
+	fetched.mutex.Lock()
+	defer fetched.mutex.Unlock()
+	if !fetched.fetchTime.IsZero() && time.Since(fetched.fetchTime) < cooldownTime {
+		return fetched.object.(T), nil
+	}
+	object, err := fetch()
+	if err != nil {
+		return object, err
+	}
+	fetched.object = object
+	fetched.fetchTime = time.Now()
+	return object, nil
+}
+
+func (cache *fetchCache) GetCertificate(ctx context.Context, name string) (azcertificates.Certificate, error) {
+	return cachedFetch(cache, certificateKind, name, func() (azcertificates.Certificate, error) {
+		return cache.Backend.GetCertificate(ctx, name)
+	})
+}
+
+func (cache *fetchCache) GetKey(ctx context.Context, name string) (azkeys.KeyBundle, error) {
+	return cachedFetch(cache, keyKind, name, func() (azkeys.KeyBundle, error) {
+		return cache.Backend.GetKey(ctx, name)
+	})
+}
+
+func (cache *fetchCache) GetSecret(ctx context.Context, name string) (azsecrets.Secret, error) {
+	return cachedFetch(cache, secretKind, name, func() (azsecrets.Secret, error) {
+		return cache.Backend.GetSecret(ctx, name)
+	})
+}
+
+// layerOf returns the cache of the vault serving an object, if the cached
+// backend combines several vaults.
+func (cache *fetchCache) layerOf(ctx context.Context, kind objectKind, name string) (Backend, error) {
+	layer, err := sourceBackend(ctx, cache.Backend, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	if layer == cache.Backend {
+		return cache, nil
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	layerCache, ok := cache.layers[layer]
+	if !ok {
+		layerCache = newFetchCache(layer)
+		cache.layers[layer] = layerCache
+	}
+	return layerCache, nil
+}
@@ -9,15 +9,29 @@ func (entry *listingEntry) Name() string {
 	return entry.name
 }
 
+// filePermissions is the ownership and mode of files and directories.
+type filePermissions struct {
+	FileMode fileMode `yaml:"file_mode"`
+	DirMode  fileMode `yaml:"dir_mode"`
+	// UID and GID are not set on files if -1, making them owned by root.
+	UID        int  `yaml:"uid"`
+	GID        int  `yaml:"gid"`
+	AllowOther bool `yaml:"allow_other"`
+}
+
+var permissions = filePermissions{
+	// read only by default, with execute bits on directories for cd
+	FileMode: 0440,
+	DirMode:  0550,
+	UID:      -1,
+	GID:      -1,
+}
+
 func (entry *listingEntry) Mode() os.FileMode {
-	// read only by default
-	bits := os.FileMode(0440)
 	if entry.IsDir() {
-		bits |= os.ModeDir
-		// Add execute bits for cd
-		bits |= 0110
+		return os.ModeDir | os.FileMode(permissions.DirMode)
 	}
-	return bits
+	return os.FileMode(permissions.FileMode)
 }
 
 func (entry *listingEntry) ModTime() time.Time {
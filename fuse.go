@@ -13,7 +13,7 @@ func (entry *listingEntry) fuseDirEntType() fuse.DirentType {
 	case entry.IsDir():
 		return fuse.DT_Dir
 	default:
-		return fuse.DT_Dir
+		return fuse.DT_File
 	}
 }
 
@@ -25,27 +25,71 @@ func (entry *listingEntry) toDirEnt() fuse.Dirent {
 	}
 }
 
+// statfsBlockSize is the block size reported to statfs callers. Key Vault
+// has no notion of blocks, so this only exists to keep tools like df happy.
+const statfsBlockSize = 4096
+
+// statfsNameLen is the maximum file name length reported to statfs callers.
+const statfsNameLen = 255
+
 type FS struct {
 	RootEntry *Dir
 }
 
+var (
+	_ fs.FS         = FS{}
+	_ fs.FSStatfser = FS{}
+)
+
 func (fs FS) Root() (fs.Node, error) {
 	return *fs.RootEntry, nil
 }
 
+// Statfs reports the number of vault objects known from the last listings
+// as used inodes, counting each object once however many files it has.
+// Nothing is fetched from Key Vault here.
+func (fs FS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
+	files := fs.RootEntry.entry.countObjects()
+	resp.Blocks = 0
+	resp.Bfree = 0
+	resp.Bavail = 0
+	resp.Files = files
+	resp.Ffree = 0
+	resp.Bsize = statfsBlockSize
+	resp.Frsize = statfsBlockSize
+	resp.Namelen = statfsNameLen
+	return nil
+}
+
 type Dir struct {
 	entry *listingEntry
 }
 
+// setOwner sets the configured owner of files and directories.
+func (p filePermissions) setOwner(a *fuse.Attr) {
+	if p.UID >= 0 {
+		a.Uid = uint32(p.UID)
+	}
+	if p.GID >= 0 {
+		a.Gid = uint32(p.GID)
+	}
+}
+
 func (d Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Inode = d.entry.inode
 	a.Mode = d.entry.Mode()
+	permissions.setOwner(a)
 	a.Size = uint64(d.entry.Size())
 	return nil
 }
 
 func (d Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	entry := d.entry.Find(name, ctx)
+	ctx, cancel := timeouts.lookupContext(ctx)
+	defer cancel()
+	entry, err := d.entry.Find(name, ctx)
+	if err != nil {
+		return nil, toFuseError(err)
+	}
 	if entry == nil {
 		return nil, syscall.ENOENT
 	}
@@ -58,33 +102,143 @@ func (d Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 
 func (d Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	dirs := []fuse.Dirent{
-		{0, fuse.DT_Dir, "."},
-		{0, fuse.DT_Dir, ".."},
+		{Inode: 0, Type: fuse.DT_Dir, Name: "."},
+		{Inode: 0, Type: fuse.DT_Dir, Name: ".."},
 	}
 
+	ctx, cancel := timeouts.listContext(ctx)
+	defer cancel()
 	err := d.entry.retrieveDirectoryListing(ctx)
 	if err != nil {
-		return nil, err
+		return nil, toFuseError(err)
 	}
 
-	for _, child := range d.entry.children {
+	for _, child := range d.entry.childEntries() {
 		dirs = append(dirs, child.toDirEnt())
 	}
 
 	return dirs, nil
 }
 
+// directIO makes files bypass the page cache and report the size last seen
+// (or zero) instead of downloading their contents on every stat.
+var directIO = false
+
 type File struct {
 	entry *listingEntry
 }
 
+var (
+	_ fs.Node            = File{}
+	_ fs.NodeOpener      = File{}
+	_ fs.NodeGetxattrer  = File{}
+	_ fs.NodeListxattrer = File{}
+)
+
 func (f File) Attr(ctx context.Context, a *fuse.Attr) error {
+	size, ok := f.entry.snapshotSize()
+	if !ok && directIO {
+		// Don't contact Key Vault just to stat, the kernel reads until EOF
+		size, _ = f.entry.cachedSize()
+		ok = true
+	}
+	if !ok {
+		ctx, cancel := timeouts.lookupContext(ctx)
+		defer cancel()
+		var err error
+		size, err = f.entry.sizeContext(ctx)
+		if errnoFor(err) == syscall.ENODATA {
+			// The view has nothing to show for this object, like the public
+			// key of a symmetric key. Stat must not fail for it, or listing
+			// the directory does; opening the file reports why it is empty.
+			size, err = 0, nil
+		}
+		if err != nil {
+			return toFuseError(err)
+		}
+	}
 	a.Inode = f.entry.inode
 	a.Mode = f.entry.Mode()
-	a.Size = uint64(f.entry.Size())
+	permissions.setOwner(a)
+	a.Size = uint64(size)
+	return nil
+}
+
+// source returns the name of the vault the file's object comes from, if the
+// backend combines several vaults.
+func (entry *listingEntry) source() (string, bool) {
+	sourcer, ok := entry.backend.(objectSourcer)
+	if !ok || entry.view == nil {
+		return "", false
+	}
+	return sourcer.Source(entry.view.kind(), entry.azKvName)
+}
+
+func (f File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if req.Name != sourceXattr {
+		return fuse.ErrNoXattr
+	}
+	source, ok := f.entry.source()
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = []byte(source)
+	return nil
+}
+
+func (f File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if _, ok := f.entry.source(); ok {
+		resp.Append(sourceXattr)
+	}
+	return nil
+}
+
+// Open downloads the file contents once. All reads through the returned
+// handle are served from this snapshot, and Attr reports the size of the
+// oldest open handle's snapshot for as long as it is open, so readers always
+// see consistent data.
+func (f File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, syscall.EACCES
+	}
+	ctx, cancel := timeouts.readContext(ctx)
+	defer cancel()
+	data, err := f.entry.Download(ctx)
+	if err != nil {
+		return nil, toFuseError(err)
+	}
+	handle := &FileHandle{entry: f.entry, data: data}
+	f.entry.addHandle(handle)
+	if directIO {
+		resp.Flags |= fuse.OpenDirectIO
+	}
+	return handle, nil
+}
+
+type FileHandle struct {
+	entry *listingEntry
+	data  []byte
+}
+
+var (
+	_ fs.HandleReader   = (*FileHandle)(nil)
+	_ fs.HandleReleaser = (*FileHandle)(nil)
+)
+
+func (h *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	if req.Offset >= int64(len(h.data)) {
+		resp.Data = nil
+		return nil
+	}
+	end := req.Offset + int64(req.Size)
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	resp.Data = h.data[req.Offset:end]
 	return nil
 }
 
-func (f File) ReadAll(ctx context.Context) ([]byte, error) {
-	return f.entry.Download(ctx)
+func (h *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.entry.removeHandle(h)
+	return nil
 }
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/fuse/fs/fstestutil"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// faultyBackend fails listings with listErr while it is set.
+type faultyBackend struct {
+	Backend
+	mutex   sync.Mutex
+	listErr error
+}
+
+func (backend *faultyBackend) setListError(err error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	backend.listErr = err
+}
+
+func (backend *faultyBackend) List(ctx context.Context, kind objectKind) ([]objectProperties, error) {
+	backend.mutex.Lock()
+	err := backend.listErr
+	backend.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return backend.Backend.List(ctx, kind)
+}
+
+// testFS is the file system under test, accessed by paths relative to its
+// root. Errors carry the errno a program would see.
+type testFS interface {
+	// readDir returns the sorted names in a directory.
+	readDir(path string) ([]string, error)
+	stat(path string) (testFileInfo, error)
+	read(path string) ([]byte, error)
+	// openForWriting opens a file write-only and closes it again.
+	openForWriting(path string) error
+	getxattr(path string, name string) (string, error)
+}
+
+type testFileInfo struct {
+	mode os.FileMode
+	size int64
+}
+
+// mountTestFS mounts the file system over backend in a temporary directory.
+func mountTestFS(t *testing.T, backend Backend) testFS {
+	return mountTestRoot(t, newTestRoot(backend))
+}
+
+// mountTestRoot mounts root in a temporary directory. Where FUSE cannot be
+// mounted, e.g. in containers without /dev/fuse or fusermount, the nodes
+// are called directly instead, like the kernel would, so that the tests
+// still run.
+func mountTestRoot(t *testing.T, root *listingEntry) testFS {
+	filesystem := FS{RootEntry: &Dir{entry: root}}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Log("FUSE is not available, calling the nodes directly:", err)
+		return nodeFS{filesystem}
+	}
+	mnt, err := fstestutil.MountedT(t, filesystem, nil)
+	if err != nil {
+		t.Log("Could not mount FUSE file system, calling the nodes directly:", err)
+		return nodeFS{filesystem}
+	}
+	t.Cleanup(mnt.Close)
+	return mountedFS(mnt.Dir)
+}
+
+// mountedFS is a file system mounted in a directory.
+type mountedFS string
+
+func (dir mountedFS) path(path string) string {
+	return filepath.Join(string(dir), path)
+}
+
+func (dir mountedFS) readDir(path string) ([]string, error) {
+	entries, err := os.ReadDir(dir.path(path))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (dir mountedFS) stat(path string) (testFileInfo, error) {
+	info, err := os.Stat(dir.path(path))
+	if err != nil {
+		return testFileInfo{}, err
+	}
+	return testFileInfo{mode: info.Mode(), size: info.Size()}, nil
+}
+
+// read reads a file of the mounted file system. Files opened through
+// os.Open are added to the runtime's netpoller, which makes the kernel ask
+// the file system for poll support from within epoll_ctl. Served from the
+// same process, that deadlocks, so the file is opened without the poller.
+func (dir mountedFS) read(path string) ([]byte, error) {
+	fd, err := syscall.Open(dir.path(path), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	file := os.NewFile(uintptr(fd), path)
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func (dir mountedFS) openForWriting(path string) error {
+	fd, err := syscall.Open(dir.path(path), syscall.O_WRONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	return syscall.Close(fd)
+}
+
+func (dir mountedFS) getxattr(path string, name string) (string, error) {
+	value := make([]byte, 256)
+	n, err := syscall.Getxattr(dir.path(path), name, value)
+	if err != nil {
+		return "", err
+	}
+	return string(value[:n]), nil
+}
+
+// nodeFS calls the nodes of a file system the way the kernel does for
+// system calls, without caching anything.
+type nodeFS struct {
+	filesystem FS
+}
+
+func (nodes nodeFS) lookup(path string) (fs.Node, error) {
+	node, err := nodes.filesystem.Root()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(path, "/") {
+		if len(name) == 0 {
+			continue
+		}
+		dir, ok := node.(fs.NodeStringLookuper)
+		if !ok {
+			return nil, syscall.ENOTDIR
+		}
+		if node, err = dir.Lookup(context.Background(), name); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func (nodes nodeFS) readDir(path string) ([]string, error) {
+	node, err := nodes.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := node.(fs.HandleReadDirAller)
+	if !ok {
+		return nil, syscall.ENOTDIR
+	}
+	entries, err := dir.ReadDirAll(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Name != "." && entry.Name != ".." {
+			names = append(names, entry.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (nodes nodeFS) stat(path string) (testFileInfo, error) {
+	node, err := nodes.lookup(path)
+	if err != nil {
+		return testFileInfo{}, err
+	}
+	var attr fuse.Attr
+	if err := node.Attr(context.Background(), &attr); err != nil {
+		return testFileInfo{}, err
+	}
+	return testFileInfo{mode: attr.Mode, size: int64(attr.Size)}, nil
+}
+
+func (nodes nodeFS) open(path string, flags fuse.OpenFlags) (fs.Handle, error) {
+	node, err := nodes.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := node.(fs.NodeOpener)
+	if !ok {
+		return nil, syscall.EISDIR
+	}
+	return file.Open(context.Background(), &fuse.OpenRequest{Flags: flags}, &fuse.OpenResponse{})
+}
+
+func (nodes nodeFS) read(path string) ([]byte, error) {
+	handle, err := nodes.open(path, fuse.OpenReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	defer handle.(fs.HandleReleaser).Release(ctx, &fuse.ReleaseRequest{})
+	var data []byte
+	for {
+		var resp fuse.ReadResponse
+		err := handle.(fs.HandleReader).Read(ctx, &fuse.ReadRequest{Offset: int64(len(data)), Size: 4096}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Data) == 0 {
+			return data, nil
+		}
+		data = append(data, resp.Data...)
+	}
+}
+
+func (nodes nodeFS) openForWriting(path string) error {
+	handle, err := nodes.open(path, fuse.OpenWriteOnly)
+	if err != nil {
+		return err
+	}
+	return handle.(fs.HandleReleaser).Release(context.Background(), &fuse.ReleaseRequest{})
+}
+
+func (nodes nodeFS) getxattr(path string, name string) (string, error) {
+	node, err := nodes.lookup(path)
+	if err != nil {
+		return "", err
+	}
+	var resp fuse.GetxattrResponse
+	if err := node.(fs.NodeGetxattrer).Getxattr(context.Background(), &fuse.GetxattrRequest{Name: name}, &resp); err != nil {
+		return "", err
+	}
+	return string(resp.Xattr), nil
+}
+
+func newTestVault(t *testing.T) *LocalBackend {
+	backend, err := NewLocalBackend(t.TempDir())
+	require.NoError(t, err)
+	return backend
+}
+
+func readDirNames(t *testing.T, filesystem testFS, path string) []string {
+	names, err := filesystem.readDir(path)
+	require.NoError(t, err)
+	return names
+}
+
+func readTestFile(t *testing.T, filesystem testFS, path string) []byte {
+	data, err := filesystem.read(path)
+	require.NoError(t, err, path)
+	return data
+}
+
+// assertObjectFiles asserts that an object is listed with the files of the
+// views offered for it, and only those.
+func assertObjectFiles(t *testing.T, names []string, kind objectKind, object objectProperties) {
+	for _, v := range viewsFor(kind) {
+		name := object.name + v.suffix()
+		if v.offered(object) {
+			assert.Contains(t, names, name)
+		} else {
+			assert.NotContains(t, names, name)
+		}
+	}
+}
+
+func Test_FUSE_listings(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestVault(t)
+	value := "hunter2"
+	_, err := backend.SetSecret(ctx, "password", azsecrets.SetSecretParameters{Value: &value})
+	require.NoError(t, err)
+	certificatePEM := string(selfSignedPEM(t, "example.com"))
+	_, err = backend.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &certificatePEM,
+	})
+	require.NoError(t, err)
+
+	filesystem := mountTestFS(t, backend)
+
+	assert.Equal(t, []string{certificatesDirName, keysDirName, secretsDirName}, readDirNames(t, filesystem, ""))
+	for _, name := range []string{certificatesDirName, keysDirName, secretsDirName} {
+		info, err := filesystem.stat(name)
+		require.NoError(t, err)
+		assert.True(t, info.mode.IsDir(), name)
+		assert.Equal(t, os.FileMode(permissions.DirMode), info.mode.Perm())
+	}
+
+	certificates := readDirNames(t, filesystem, certificatesDirName)
+	assertObjectFiles(t, certificates, certificateKind, objectProperties{name: "web"})
+	assert.Len(t, certificates, len(viewsFor(certificateKind)))
+	secrets := readDirNames(t, filesystem, secretsDirName)
+	assertObjectFiles(t, secrets, secretKind, objectProperties{name: "password"})
+	assertObjectFiles(t, secrets, secretKind, objectProperties{name: "web", contentType: pemContentType})
+	assert.Empty(t, readDirNames(t, filesystem, keysDirName))
+	for _, name := range secrets {
+		info, err := filesystem.stat(filepath.Join(secretsDirName, name))
+		require.NoError(t, err)
+		assert.True(t, info.mode.IsRegular(), "%s is a file", name)
+		assert.Equal(t, os.FileMode(permissions.FileMode), info.mode.Perm())
+	}
+
+	info, err := filesystem.stat(filepath.Join(secretsDirName, "password"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(value)), info.size)
+	assert.Equal(t, os.FileMode(0440), info.mode.Perm(), "the default file mode")
+}
+
+func Test_FS_Statfs(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestVault(t)
+	setTestSecret(t, backend, "password", "hunter2")
+	certificatePEM := string(selfSignedPEM(t, "example.com"))
+	_, err := backend.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &certificatePEM,
+	})
+	require.NoError(t, err)
+	root := newTestRoot(backend)
+	filesystem := FS{RootEntry: &Dir{entry: root}}
+
+	var resp fuse.StatfsResponse
+	require.NoError(t, filesystem.Statfs(ctx, &fuse.StatfsRequest{}, &resp))
+	assert.Zero(t, resp.Files, "nothing is listed yet")
+
+	for _, name := range []string{certificatesDirName, secretsDirName} {
+		dir, err := root.Find(name, ctx)
+		require.NoError(t, err)
+		require.NoError(t, dir.retrieveDirectoryListing(ctx))
+	}
+	require.NoError(t, filesystem.Statfs(ctx, &fuse.StatfsRequest{}, &resp))
+	assert.Equal(t, uint64(3), resp.Files, "the certificate, its secret and the password, once each")
+	assert.Equal(t, uint32(statfsBlockSize), resp.Bsize)
+}
+
+func Test_File_snapshots(t *testing.T) {
+	ctx := context.Background()
+	// Every open and stat fetches the current value
+	defer func(cooldown time.Duration) { cooldownTime = cooldown }(cooldownTime)
+	cooldownTime = 0
+	backend := newTestVault(t)
+	setTestSecret(t, backend, "password", "short")
+	secrets, err := newTestRoot(backend).Find(secretsDirName, ctx)
+	require.NoError(t, err)
+	entry, err := secrets.Find("password", ctx)
+	require.NoError(t, err)
+	file := File{entry}
+	open := func() *FileHandle {
+		handle, err := file.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		require.NoError(t, err)
+		return handle.(*FileHandle)
+	}
+	size := func() uint64 {
+		var attr fuse.Attr
+		require.NoError(t, file.Attr(ctx, &attr))
+		return attr.Size
+	}
+
+	first := open()
+	setTestSecret(t, backend, "password", "much longer")
+	second := open()
+	assert.Equal(t, uint64(len("short")), size(), "the oldest handle's size while it is open")
+	var resp fuse.ReadResponse
+	require.NoError(t, first.Read(ctx, &fuse.ReadRequest{Size: 100}, &resp))
+	assert.Equal(t, "short", string(resp.Data))
+	require.NoError(t, second.Read(ctx, &fuse.ReadRequest{Size: 100}, &resp))
+	assert.Equal(t, "much longer", string(resp.Data))
+
+	require.NoError(t, first.Release(ctx, &fuse.ReleaseRequest{}))
+	assert.Equal(t, uint64(len("much longer")), size(), "then the next handle's")
+	require.NoError(t, second.Release(ctx, &fuse.ReleaseRequest{}))
+	setTestSecret(t, backend, "password", "latest")
+	assert.Equal(t, uint64(len("latest")), size(), "without handles, the current value's")
+}
+
+func Test_FUSE_contents(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestVault(t)
+
+	value := "hunter2"
+	_, err := backend.SetSecret(ctx, "password", azsecrets.SetSecretParameters{Value: &value})
+	require.NoError(t, err)
+
+	bundle, err := parsePEMBundle(selfSignedPEM(t, "example.com"))
+	require.NoError(t, err)
+	pfx, err := pkcs12.Modern.Encode(bundle.key, bundle.leaf, nil, "")
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(pfx)
+	_, err = backend.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &encoded,
+	})
+	require.NoError(t, err)
+
+	filesystem := mountTestFS(t, backend)
+	read := func(path ...string) []byte {
+		return readTestFile(t, filesystem, filepath.Join(path...))
+	}
+
+	assert.Equal(t, "hunter2", string(read(secretsDirName, "password")))
+
+	var secret azsecrets.Secret
+	require.NoError(t, json.Unmarshal(read(secretsDirName, "password.response"), &secret))
+	assert.Equal(t, "hunter2", *secret.Value)
+	assert.Equal(t, "password", secret.ID.Name())
+
+	assert.Equal(t, pfx, read(secretsDirName, "web.pfx"))
+
+	assert.Equal(t, bundle.leaf.Raw, read(certificatesDirName, "web"))
+	block, rest := pem.Decode(read(certificatesDirName, "web.pem"))
+	require.NotNil(t, block)
+	assert.Equal(t, bundle.leaf.Raw, block.Bytes)
+	assert.Empty(t, rest)
+	assert.Empty(t, read(certificatesDirName, "web.chain.pem"),
+		"the chain of a self-signed certificate has no issuers")
+
+	var certificate azcertificates.Certificate
+	require.NoError(t, json.Unmarshal(read(certificatesDirName, "web.response"), &certificate))
+	assert.Equal(t, bundle.leaf.Raw, certificate.CER)
+}
+
+func Test_FUSE_errors(t *testing.T) {
+	ctx := context.Background()
+	backend := &faultyBackend{Backend: newTestVault(t)}
+	value := "hunter2"
+	_, err := backend.SetSecret(ctx, "password", azsecrets.SetSecretParameters{Value: &value})
+	require.NoError(t, err)
+
+	filesystem := mountTestFS(t, backend)
+	password := filepath.Join(secretsDirName, "password")
+
+	_, err = filesystem.stat(filepath.Join(secretsDirName, "missing"))
+	assert.Equal(t, syscall.ENOENT, errnoFor(err))
+	_, err = filesystem.stat("missing")
+	assert.Equal(t, syscall.ENOENT, errnoFor(err))
+
+	err = filesystem.openForWriting(password)
+	assert.Equal(t, syscall.EACCES, errnoFor(err), "files are read-only")
+
+	require.NoError(t, backend.Delete(ctx, secretKind, "password"))
+	defer func(cooldown time.Duration) { cooldownTime = cooldown }(cooldownTime)
+	cooldownTime = 0
+	_, err = filesystem.read(password)
+	assert.Equal(t, syscall.ENOENT, errnoFor(err), "objects deleted after listing are not found once fetched again")
+
+	backend.setListError(errCircuitOpen)
+	_, err = filesystem.readDir(keysDirName)
+	assert.Equal(t, syscall.EAGAIN, errnoFor(err), "unavailable vault without a cached listing")
+}
+
+func Test_FUSE_noData(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestVault(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwk, err := jsonWebKeyFromPrivate(rsaKey)
+	require.NoError(t, err)
+	_, err = backend.ImportKey(ctx, "signing", azkeys.ImportKeyParameters{Key: jwk})
+	require.NoError(t, err)
+	oct := azkeys.KeyTypeOct
+	_, err = backend.ImportKey(ctx, "wrapping", azkeys.ImportKeyParameters{Key: &azkeys.JSONWebKey{Kty: &oct, K: []byte("k")}})
+	require.NoError(t, err)
+
+	filesystem := mountTestFS(t, backend)
+	for _, name := range readDirNames(t, filesystem, keysDirName) {
+		_, err := filesystem.stat(filepath.Join(keysDirName, name))
+		assert.NoError(t, err, "%s can be listed with its size", name)
+	}
+
+	info, err := filesystem.stat(filepath.Join(keysDirName, "signing"))
+	require.NoError(t, err)
+	assert.Zero(t, info.size)
+	_, err = filesystem.read(filepath.Join(keysDirName, "signing"))
+	assert.Equal(t, syscall.ENODATA, errnoFor(err), "the private part of RSA keys is never returned")
+	_, err = filesystem.read(filepath.Join(keysDirName, "wrapping.pub.pem"))
+	assert.Equal(t, syscall.ENODATA, errnoFor(err), "symmetric keys have no public key")
+	assert.Equal(t, "k", string(readTestFile(t, filesystem, filepath.Join(keysDirName, "wrapping"))))
+}
+
+// countingBackend counts the objects fetched from it.
+type countingBackend struct {
+	Backend
+	certificates atomic.Int32
+	secrets      atomic.Int32
+}
+
+func (backend *countingBackend) GetCertificate(ctx context.Context, name string) (azcertificates.Certificate, error) {
+	backend.certificates.Add(1)
+	return backend.Backend.GetCertificate(ctx, name)
+}
+
+func (backend *countingBackend) GetSecret(ctx context.Context, name string) (azsecrets.Secret, error) {
+	backend.secrets.Add(1)
+	return backend.Backend.GetSecret(ctx, name)
+}
+
+func Test_FUSE_certificateFiles(t *testing.T) {
+	ctx := context.Background()
+	vault := newTestVault(t)
+	_, _, leaf := testHierarchy(t)
+	// Imported without its key, like certificates with non-exportable keys
+	encoded := base64.StdEncoding.EncodeToString(encodeCertificate(leaf.certificate.Raw))
+	_, err := vault.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &encoded,
+	})
+	require.NoError(t, err)
+	backend := &countingBackend{Backend: vault}
+
+	filesystem := mountTestFS(t, backend)
+	names := readDirNames(t, filesystem, certificatesDirName)
+	for i := 0; i < 2; i++ {
+		for _, name := range names {
+			_, err := filesystem.stat(filepath.Join(certificatesDirName, name))
+			assert.NoError(t, err, "%s can be listed with its size", name)
+		}
+	}
+	assert.Equal(t, int32(1), backend.certificates.Load(), "the certificate is fetched once for all its files")
+	assert.Equal(t, int32(1), backend.secrets.Load(), "and so is its secret")
+
+	for _, name := range []string{"web.key.pem", "web.bundle.pem"} {
+		info, err := filesystem.stat(filepath.Join(certificatesDirName, name))
+		require.NoError(t, err)
+		assert.Zero(t, info.size, name)
+		_, err = filesystem.read(filepath.Join(certificatesDirName, name))
+		assert.Equal(t, syscall.ENODATA, errnoFor(err), "%s without an exportable key", name)
+	}
+	assert.NotEmpty(t, readTestFile(t, filesystem, filepath.Join(certificatesDirName, "web.fullchain.pem")))
+}
+
+func Test_FUSE_refresh(t *testing.T) {
+	ctx := context.Background()
+	backend := &faultyBackend{Backend: newTestVault(t)}
+	value := "hunter2"
+	_, err := backend.SetSecret(ctx, "first", azsecrets.SetSecretParameters{Value: &value})
+	require.NoError(t, err)
+
+	filesystem := mountTestFS(t, backend)
+	secrets := readDirNames(t, filesystem, secretsDirName)
+	assertObjectFiles(t, secrets, secretKind, objectProperties{name: "first"})
+
+	_, err = backend.SetSecret(ctx, "second", azsecrets.SetSecretParameters{Value: &value})
+	require.NoError(t, err)
+	assert.Equal(t, secrets, readDirNames(t, filesystem, secretsDirName),
+		"listings are cached for cooldownTime")
+
+	defer func(cooldown time.Duration) { cooldownTime = cooldown }(cooldownTime)
+	cooldownTime = 0
+	refreshed := readDirNames(t, filesystem, secretsDirName)
+	assertObjectFiles(t, refreshed, secretKind, objectProperties{name: "first"})
+	assertObjectFiles(t, refreshed, secretKind, objectProperties{name: "second"})
+
+	updated := "correct horse"
+	_, err = backend.SetSecret(ctx, "second", azsecrets.SetSecretParameters{Value: &updated})
+	require.NoError(t, err)
+	assert.Equal(t, updated, string(readTestFile(t, filesystem, filepath.Join(secretsDirName, "second"))),
+		"every open reads the current value")
+
+	backend.setListError(errCircuitOpen)
+	assert.Equal(t, refreshed, readDirNames(t, filesystem, secretsDirName),
+		"the last listing is served while the vault is unavailable")
+}
+
+func Test_FUSE_vaults(t *testing.T) {
+	ctx := context.Background()
+	app, shared := newTestVault(t), newTestVault(t)
+	value := "hunter2"
+	_, err := app.SetSecret(ctx, "password", azsecrets.SetSecretParameters{Value: &value})
+	require.NoError(t, err)
+	certificatePEM := string(selfSignedPEM(t, "example.com"))
+	_, err = shared.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &certificatePEM,
+	})
+	require.NoError(t, err)
+
+	root := newRootEntry(nil)
+	root.addVault("app", app)
+	root.addVault("shared-certs", shared)
+	filesystem := mountTestRoot(t, root)
+
+	assert.Equal(t, []string{"app", "shared-certs"}, readDirNames(t, filesystem, ""))
+	assert.Equal(t, []string{certificatesDirName, keysDirName, secretsDirName}, readDirNames(t, filesystem, "app"))
+	secrets := readDirNames(t, filesystem, filepath.Join("app", secretsDirName))
+	assertObjectFiles(t, secrets, secretKind, objectProperties{name: "password"})
+	assert.Len(t, secrets, 2)
+	assertObjectFiles(t, readDirNames(t, filesystem, filepath.Join("shared-certs", certificatesDirName)),
+		certificateKind, objectProperties{name: "web"})
+
+	password := filepath.Join("app", secretsDirName, "password")
+	assert.Equal(t, value, string(readTestFile(t, filesystem, password)))
+
+	_, err = filesystem.getxattr(password, sourceXattr)
+	assert.Equal(t, syscall.ENODATA, errnoFor(err), "only overlays have sources")
+}
+
+func Test_FUSE_overlay(t *testing.T) {
+	prod, baseline := newTestVault(t), newTestVault(t)
+	setTestSecret(t, prod, "database", "prod database")
+	setTestSecret(t, baseline, "database", "baseline database")
+	setTestSecret(t, baseline, "smtp", "baseline smtp")
+
+	filesystem := mountTestFS(t, newOverlayBackend([]overlayLayer{{"prod", prod}, {"baseline", baseline}}))
+	secrets := readDirNames(t, filesystem, secretsDirName)
+	assertObjectFiles(t, secrets, secretKind, objectProperties{name: "database"})
+	assertObjectFiles(t, secrets, secretKind, objectProperties{name: "smtp"})
+	assert.Len(t, secrets, 4, "each name once")
+
+	assert.Equal(t, "prod database", string(readTestFile(t, filesystem, filepath.Join(secretsDirName, "database"))))
+
+	source, err := filesystem.getxattr(filepath.Join(secretsDirName, "smtp"), sourceXattr)
+	require.NoError(t, err)
+	assert.Equal(t, "baseline", source)
+	source, err = filesystem.getxattr(filepath.Join(secretsDirName, "database.response"), sourceXattr)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", source)
+}
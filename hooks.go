@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+)
+
+// mountHooks are shell commands run around the lifetime of the mount. They
+// get the mount point and source in AZKV_MOUNT_POINT and AZKV_SOURCE.
+type mountHooks struct {
+	OnMount   string `yaml:"on_mount"`
+	OnUnmount string `yaml:"on_unmount"`
+}
+
+var hooks mountHooks
+
+// runHook runs a hook command and logs its failure. Hooks cannot stop the
+// mount from going ahead.
+func runHook(name string, command string, mountPoint string, source string) {
+	if len(command) == 0 {
+		return
+	}
+	log.Println("Running", name, "hook")
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), "AZKV_MOUNT_POINT="+mountPoint, "AZKV_SOURCE="+source)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Println("Hook", name, "failed:", err)
+	}
+}
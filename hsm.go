@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/pkg/errors"
+)
+
+// randomFileName is the file in the root of a Managed HSM that reads
+// random bytes generated by the HSM.
+const randomFileName = "random"
+
+// randomFileSize is the number of random bytes read from the random file
+// each time it is opened.
+const randomFileSize = 32
+
+// managedHSM is implemented by backends that may be a Managed HSM, which
+// only stores keys but can generate random bytes.
+type managedHSM interface {
+	IsManagedHSM() bool
+	GetRandomBytes(ctx context.Context, count int) ([]byte, error)
+}
+
+var _ managedHSM = (*AzKVClients)(nil)
+
+// isManagedHSMURL reports whether a vault URL is a Managed HSM, like
+// https://name.managedhsm.azure.net.
+func isManagedHSMURL(vaultURL string) bool {
+	parsed, err := url.Parse(vaultURL)
+	return err == nil && strings.Contains(parsed.Hostname(), ".managedhsm.")
+}
+
+// managedHSMScope is the token scope of a Managed HSM, its domain without
+// the HSM name.
+func managedHSMScope(vaultURL string) string {
+	parsed, err := url.Parse(vaultURL)
+	if err != nil {
+		return ""
+	}
+	_, domain, _ := strings.Cut(parsed.Hostname(), ".")
+	return "https://" + domain + "/.default"
+}
+
+// errHSMKeysOnly is returned for secrets and certificates of a Managed HSM.
+var errHSMKeysOnly = errors.WithMessage(syscall.EOPNOTSUPP, "Managed HSM only stores keys")
+
+// isHSMSupported fails for the kinds of objects a Managed HSM cannot store.
+func (clients *AzKVClients) isHSMSupported(kind objectKind) error {
+	if clients.hsm && kind != keyKind {
+		return errHSMKeysOnly
+	}
+	return nil
+}
+
+func (clients *AzKVClients) IsManagedHSM() bool {
+	return clients.hsm
+}
+
+func (clients *AzKVClients) GetRandomBytes(ctx context.Context, count int) ([]byte, error) {
+	if !clients.hsm {
+		return nil, errors.WithMessage(syscall.EOPNOTSUPP, "only Managed HSM generates random bytes")
+	}
+	count32 := int32(count)
+	response, err := clients.keys.GetRandomBytes(ctx, azkeys.GetRandomBytesParameters{Count: &count32}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.Value, nil
+}
+
+// randomView is the random file of a Managed HSM. It does not represent an
+// object; every time it is opened, new random bytes are generated.
+type randomView struct{}
+
+var _ view = randomView{}
+
+func (randomView) kind() objectKind {
+	return keyKind
+}
+
+func (randomView) suffix() string {
+	return ""
+}
+
+func (randomView) offered(objectProperties) bool {
+	return false
+}
+
+// fetch returns the HSM without contacting it, so that only reading the
+// file generates random bytes.
+func (randomView) fetch(ctx context.Context, backend Backend, name string) (any, error) {
+	hsm, ok := backend.(managedHSM)
+	if !ok || !hsm.IsManagedHSM() {
+		return nil, errors.WithMessage(syscall.EOPNOTSUPP, "not a Managed HSM")
+	}
+	return hsm, nil
+}
+
+func (randomView) render(ctx context.Context, object any) ([]byte, error) {
+	return object.(managedHSM).GetRandomBytes(ctx, randomFileSize)
+}
+
+func (randomView) size(ctx context.Context, object any) (int64, error) {
+	return randomFileSize, nil
+}
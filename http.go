@@ -2,18 +2,50 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
 )
 
-var transport = &http.Transport{
-	ReadBufferSize:  32 * 1024,
-	IdleConnTimeout: time.Minute * 1,
-	TLSClientConfig: &tls.Config{
-		InsecureSkipVerify: false,
-	},
+// httpConfig configures the HTTP transport shared by the credential, the
+// Key Vault clients and certificate chain downloads.
+type httpConfig struct {
+	// Proxy is the proxy for all requests. If empty, HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY from the environment are used.
+	// The managed identity endpoint of Azure VMs is never proxied.
+	Proxy string `yaml:"proxy"`
+	// NoProxy lists hosts, domains (".example.com") and networks
+	// ("10.0.0.0/8") reached without the proxy.
+	NoProxy []string `yaml:"no_proxy"`
+	// CABundle is a PEM file with certificates trusted in addition to the
+	// system's, e.g. of a TLS-inspecting proxy.
+	CABundle string `yaml:"ca_bundle"`
+
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
+}
+
+var defaultHTTPConfig = httpConfig{
+	DialTimeout:         30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+	IdleConnTimeout:     time.Minute,
 }
 
+// imdsAddress is the managed identity endpoint on Azure VMs, which must
+// never be reached through a proxy.
+const imdsAddress = "169.254.169.254"
+
+var transport = mustNewTransport(defaultHTTPConfig)
+
 var httpClient = &http.Client{
 	Transport: transport,
 }
@@ -21,3 +53,82 @@ var httpClient = &http.Client{
 func client() *http.Client {
 	return httpClient
 }
+
+func mustNewTransport(config httpConfig) *http.Transport {
+	transport, err := config.newTransport()
+	if err != nil {
+		panic(err)
+	}
+	return transport
+}
+
+// newTransport creates a transport as configured.
+func (config httpConfig) newTransport() (*http.Transport, error) {
+	noProxy := append([]string{imdsAddress}, config.NoProxy...)
+	proxyConfig := &httpproxy.Config{HTTPProxy: config.Proxy, HTTPSProxy: config.Proxy}
+	if len(config.Proxy) == 0 {
+		proxyConfig = httpproxy.FromEnvironment()
+		if len(proxyConfig.NoProxy) != 0 {
+			noProxy = append(noProxy, proxyConfig.NoProxy)
+		}
+	}
+	proxyConfig.NoProxy = strings.Join(noProxy, ",")
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+	if len(config.CABundle) != 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read the CA bundle")
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("CA bundle %s contains no PEM certificates", config.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Transport{
+		Proxy: func(request *http.Request) (*url.URL, error) {
+			return proxyFunc(request.URL)
+		},
+		DialContext: (&net.Dialer{
+			Timeout:   config.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		ReadBufferSize:        32 * 1024,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSClientConfig:       tlsConfig,
+	}, nil
+}
+
+func validateHTTP(report func(format string, args ...any), config httpConfig) {
+	if len(config.Proxy) != 0 {
+		if parsed, err := url.Parse(config.Proxy); err != nil || len(parsed.Scheme) == 0 || len(parsed.Host) == 0 {
+			report("http.proxy %q is not a URL", config.Proxy)
+		}
+	}
+	if config.DialTimeout < 0 || config.TLSHandshakeTimeout < 0 ||
+		config.ResponseHeaderTimeout < 0 || config.IdleConnTimeout < 0 {
+		report("http timeouts must not be negative")
+	}
+}
+
+// configureHTTP replaces the shared transport.
+func configureHTTP(config httpConfig) error {
+	configured, err := config.newTransport()
+	if err != nil {
+		return err
+	}
+	transport = configured
+	httpClient = &http.Client{Transport: transport}
+	return nil
+}
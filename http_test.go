@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_httpConfig_proxy(t *testing.T) {
+	transport, err := httpConfig{
+		Proxy:   "http://proxy.example.com:3128",
+		NoProxy: []string{".internal.example.com", "10.0.0.0/8"},
+	}.newTransport()
+	require.NoError(t, err)
+
+	for target, proxied := range map[string]bool{
+		"https://app.vault.azure.net/secrets":   true,
+		"https://login.microsoftonline.com/":    true,
+		"https://vault.internal.example.com/":   false,
+		"https://10.1.2.3/":                     false,
+		"http://169.254.169.254/metadata/token": false,
+	} {
+		parsed, err := url.Parse(target)
+		require.NoError(t, err)
+		proxy, err := transport.Proxy(&http.Request{URL: parsed})
+		require.NoError(t, err)
+		if proxied {
+			require.NotNil(t, proxy, target)
+			assert.Equal(t, "proxy.example.com:3128", proxy.Host)
+		} else {
+			assert.Nil(t, proxy, target)
+		}
+	}
+}
+
+func Test_httpConfig_caBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	transport, err := httpConfig{}.newTransport()
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(server.URL)
+	assert.Error(t, err, "the test server's certificate is not trusted by default")
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(bundle, certificate, 0600))
+	transport, err = httpConfig{CABundle: bundle}.newTransport()
+	require.NoError(t, err)
+	response, err := (&http.Client{Transport: transport}).Get(server.URL)
+	require.NoError(t, err)
+	_ = response.Body.Close()
+
+	require.NoError(t, os.WriteFile(bundle, []byte("not a certificate"), 0600))
+	_, err = httpConfig{CABundle: bundle}.newTransport()
+	assert.ErrorContains(t, err, "contains no PEM certificates")
+}
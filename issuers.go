@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// chainConfig says where the issuers of certificates come from when their
+// chains are built.
+type chainConfig struct {
+	// Issuers are files, or directories of files, with intermediate and root
+	// certificates as PEM, DER or PKCS#7. They are searched first.
+	Issuers []string `yaml:"issuers"`
+	// SystemRoots searches the system trust store next.
+	SystemRoots bool `yaml:"system_roots"`
+	// Download follows the issuer URLs in certificates whose issuer was not
+	// found locally.
+	Download bool `yaml:"download"`
+	// CacheDir keeps downloaded issuers, so that they are downloaded once.
+	// Empty disables the cache.
+	CacheDir string `yaml:"cache_dir"`
+}
+
+// defaultChains is the default configuration of a mount: it searches the
+// system trust store, downloads issuers and caches them in the user's cache
+// directory.
+func defaultChains() chainConfig {
+	config := chainConfig{
+		SystemRoots: true,
+		Download:    true,
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		config.CacheDir = filepath.Join(cacheDir, "azkv", "issuers")
+	}
+	return config
+}
+
+// issuerStore finds the issuers of certificates as configured.
+type issuerStore struct {
+	config chainConfig
+	// local holds the certificates of config.Issuers by raw subject.
+	local map[string][]*x509.Certificate
+	// roots is the system trust store, if it is searched.
+	roots *x509.CertPool
+}
+
+// issuers is set by configureChains. Until then no issuers are found, so
+// chains end at the certificate itself.
+var issuers *issuerStore
+
+// newIssuerStore loads the configured issuer files and trust store.
+func newIssuerStore(config chainConfig) (*issuerStore, error) {
+	store := &issuerStore{config: config, local: map[string][]*x509.Certificate{}}
+	for _, location := range config.Issuers {
+		certificates, err := loadIssuers(location)
+		if err != nil {
+			return nil, err
+		}
+		for _, certificate := range certificates {
+			store.add(certificate)
+		}
+	}
+	if config.SystemRoots {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			log.Println("Not searching the system trust store for issuers:", err)
+		}
+		store.roots = roots
+	}
+	return store, nil
+}
+
+// loadIssuers reads the certificates in a file or a directory of files.
+// Files in a directory that hold no certificates are skipped.
+func loadIssuers(location string) ([]*x509.Certificate, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read issuers")
+	}
+	if !info.IsDir() {
+		return loadIssuerFile(location)
+	}
+	entries, err := os.ReadDir(location)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read issuers")
+	}
+	var certificates []*x509.Certificate
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		loaded, err := loadIssuerFile(filepath.Join(location, entry.Name()))
+		if err != nil {
+			log.Println("Skipping", err)
+			continue
+		}
+		certificates = append(certificates, loaded...)
+	}
+	return certificates, nil
+}
+
+func loadIssuerFile(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read issuers")
+	}
+	certificates, err := parseCertificates(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s", file)
+	}
+	return certificates, nil
+}
+
+func (store *issuerStore) add(certificate *x509.Certificate) {
+	subject := string(certificate.RawSubject)
+	store.local[subject] = append(store.local[subject], certificate)
+}
+
+// configureChains replaces the issuer store.
+func configureChains(config chainConfig) error {
+	store, err := newIssuerStore(config)
+	if err != nil {
+		return err
+	}
+	issuers = store
+	return nil
+}
+
+// issuerOf returns the certificate that signed certificate, searching the
+// configured issuers, the system trust store and then the issuer URLs. It
+// returns nil if no issuer is to be found.
+func (store *issuerStore) issuerOf(ctx context.Context, certificate *x509.Certificate) (*x509.Certificate, error) {
+	if store == nil {
+		return nil, nil
+	}
+	for _, candidate := range store.local[string(certificate.RawIssuer)] {
+		if certificate.CheckSignatureFrom(candidate) == nil {
+			return candidate, nil
+		}
+	}
+	if root := store.root(certificate); root != nil {
+		return root, nil
+	}
+	if !store.config.Download {
+		if len(certificate.IssuingCertificateURL) != 0 {
+			log.Println("Issuer of", certificate.Subject, "not found locally and downloads are disabled")
+		}
+		return nil, nil
+	}
+	return store.download(ctx, certificate)
+}
+
+// root returns the certificate of the system trust store that signed
+// certificate.
+func (store *issuerStore) root(certificate *x509.Certificate) *x509.Certificate {
+	if store.roots == nil {
+		return nil
+	}
+	chains, err := certificate.Verify(x509.VerifyOptions{
+		Roots: store.roots,
+		// The root must have been valid when the certificate was issued,
+		// whether or not the certificate still is.
+		CurrentTime: certificate.NotBefore,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil
+	}
+	for _, chain := range chains {
+		if len(chain) > 1 {
+			return chain[1]
+		}
+	}
+	return nil
+}
+
+// download follows the issuer URLs of certificate to the certificate that
+// signed it, through the cache. It returns nil if the certificate names no
+// issuer to download.
+func (store *issuerStore) download(ctx context.Context, certificate *x509.Certificate) (*x509.Certificate, error) {
+	var lastErr error
+	for _, location := range certificate.IssuingCertificateURL {
+		if parsed, err := url.Parse(location); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			log.Println("Skipping issuer URL", location, "of", certificate.Subject)
+			continue
+		}
+		if cached := store.cached(certificate, location); cached != nil {
+			return cached, nil
+		}
+		log.Println("Downloading issuer of", certificate.Subject, "from", location)
+		candidates, err := downloadCertificates(ctx, location)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "could not download issuer of %s from %s", certificate.Subject, location)
+			log.Println(lastErr)
+			continue
+		}
+		for _, candidate := range candidates {
+			if certificate.CheckSignatureFrom(candidate) == nil {
+				store.cache(location, candidate)
+				return candidate, nil
+			}
+		}
+		lastErr = errors.Errorf("%s does not have the issuer of %s", location, certificate.Subject)
+		log.Println(lastErr)
+	}
+	return nil, lastErr
+}
+
+func (store *issuerStore) cacheFile(location string) string {
+	sum := sha256.Sum256([]byte(location))
+	return filepath.Join(store.config.CacheDir, hex.EncodeToString(sum[:])+".pem")
+}
+
+// cached returns the issuer of certificate downloaded from location before.
+func (store *issuerStore) cached(certificate *x509.Certificate, location string) *x509.Certificate {
+	if len(store.config.CacheDir) == 0 {
+		return nil
+	}
+	candidates, err := loadIssuerFile(store.cacheFile(location))
+	if err != nil {
+		return nil
+	}
+	for _, candidate := range candidates {
+		if certificate.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// cache keeps an issuer downloaded from location. Failing to is not an
+// error, the issuer is downloaded again next time.
+func (store *issuerStore) cache(location string, issuer *x509.Certificate) {
+	if len(store.config.CacheDir) == 0 {
+		return
+	}
+	if err := writePrivateFile(store.cacheFile(location), encodeCertificate(issuer.Raw)); err != nil {
+		log.Println("Could not cache issuer from", location, err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useIssuers builds chains with the given store for the rest of the test.
+func useIssuers(t *testing.T, store *issuerStore) {
+	original := issuers
+	t.Cleanup(func() { issuers = original })
+	issuers = store
+}
+
+// testAIAHierarchy creates a root, intermediate and leaf whose issuer URLs
+// point to the server, without serving anything yet.
+func testAIAHierarchy(t *testing.T, server *aiaServer) (root, intermediate, leaf *x509.Certificate) {
+	rootCA := issueTestCertificate(t, "Root", nil, true)
+	intermediateCA := issueTestCertificate(t, "Intermediate", rootCA, true, server.URL+"/root.crt")
+	leaf = issueTestCertificate(t, "Leaf", intermediateCA, false, server.URL+"/intermediate.crt").certificate
+	root, intermediate = rootCA.certificate, intermediateCA.certificate
+	return root, intermediate, leaf
+}
+
+func Test_issuerStore_local(t *testing.T) {
+	ctx := context.Background()
+	server := newAIAServer(t)
+	root, intermediate, leaf := testAIAHierarchy(t, server)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "intermediate.pem"), encodeCertificate(intermediate.Raw), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "root.p7c"), marshalPKCS7(t, root), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0644))
+
+	store, err := newIssuerStore(chainConfig{Issuers: []string{dir}})
+	require.NoError(t, err)
+	useIssuers(t, store)
+	chain, err := buildCertificateChain(ctx, leaf.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Leaf", "Intermediate", "Root"}, commonNames(chain), "found without downloading")
+
+	store, err = newIssuerStore(chainConfig{Issuers: []string{filepath.Join(dir, "intermediate.pem")}})
+	require.NoError(t, err)
+	useIssuers(t, store)
+	chain, err = buildCertificateChain(ctx, leaf.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Leaf", "Intermediate"}, commonNames(chain),
+		"the chain ends where issuers are neither local nor to be downloaded")
+
+	_, err = newIssuerStore(chainConfig{Issuers: []string{filepath.Join(dir, "README")}})
+	assert.Error(t, err, "issuer files named explicitly must hold certificates")
+	_, err = newIssuerStore(chainConfig{Issuers: []string{filepath.Join(dir, "missing")}})
+	assert.Error(t, err)
+}
+
+func Test_issuerStore_systemRoots(t *testing.T) {
+	server := newAIAServer(t)
+	root, intermediate, _ := testAIAHierarchy(t, server)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	store := &issuerStore{config: chainConfig{SystemRoots: true}, roots: roots}
+	issuer, err := store.issuerOf(context.Background(), intermediate)
+	require.NoError(t, err)
+	require.NotNil(t, issuer)
+	assert.Equal(t, root.Raw, issuer.Raw)
+}
+
+func Test_issuerStore_cache(t *testing.T) {
+	ctx := context.Background()
+	server := newAIAServer(t)
+	root, intermediate, leaf := testAIAHierarchy(t, server)
+	server.files["/intermediate.crt"] = intermediate.Raw
+	server.files["/root.crt"] = root.Raw
+
+	config := chainConfig{Download: true, CacheDir: t.TempDir()}
+	store, err := newIssuerStore(config)
+	require.NoError(t, err)
+	useIssuers(t, store)
+	chain, err := buildCertificateChain(ctx, leaf.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Leaf", "Intermediate", "Root"}, commonNames(chain))
+
+	delete(server.files, "/intermediate.crt")
+	delete(server.files, "/root.crt")
+	store, err = newIssuerStore(config)
+	require.NoError(t, err)
+	useIssuers(t, store)
+	chain, err = buildCertificateChain(ctx, leaf.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Leaf", "Intermediate", "Root"}, commonNames(chain), "issuers come from the cache")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"math/big"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/pkg/errors"
+)
+
+// curveNames maps the curves supported by Key Vault to their names.
+var curveNames = map[elliptic.Curve]azkeys.CurveName{
+	elliptic.P256(): azkeys.CurveNameP256,
+	elliptic.P384(): azkeys.CurveNameP384,
+	elliptic.P521(): azkeys.CurveNameP521,
+}
+
+// curveSize returns the length of a coordinate of curve in bytes.
+func curveSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+// jsonWebKeyFromPrivate converts an RSA or EC private key to a JSON web key
+// including the private parts.
+func jsonWebKeyFromPrivate(key crypto.PrivateKey) (*azkeys.JSONWebKey, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		key.Precompute()
+		kty := azkeys.KeyTypeRSA
+		return &azkeys.JSONWebKey{
+			Kty: &kty,
+			N:   key.N.Bytes(),
+			E:   big.NewInt(int64(key.E)).Bytes(),
+			D:   key.D.Bytes(),
+			P:   key.Primes[0].Bytes(),
+			Q:   key.Primes[1].Bytes(),
+			DP:  key.Precomputed.Dp.Bytes(),
+			DQ:  key.Precomputed.Dq.Bytes(),
+			QI:  key.Precomputed.Qinv.Bytes(),
+		}, nil
+	case *ecdsa.PrivateKey:
+		crv, ok := curveNames[key.Curve]
+		if !ok {
+			return nil, errors.Errorf("unsupported curve %s", key.Curve.Params().Name)
+		}
+		kty := azkeys.KeyTypeEC
+		size := curveSize(key.Curve)
+		return &azkeys.JSONWebKey{
+			Kty: &kty,
+			Crv: &crv,
+			X:   key.X.FillBytes(make([]byte, size)),
+			Y:   key.Y.FillBytes(make([]byte, size)),
+			D:   key.D.FillBytes(make([]byte, size)),
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %T", key)
+	}
+}
+
+// publicJSONWebKey returns a copy of jwk without private or symmetric key
+// material, which is what Key Vault returns for keys.
+func publicJSONWebKey(jwk *azkeys.JSONWebKey) *azkeys.JSONWebKey {
+	public := *jwk
+	public.D = nil
+	public.P = nil
+	public.Q = nil
+	public.DP = nil
+	public.DQ = nil
+	public.QI = nil
+	public.K = nil
+	public.T = nil
+	return &public
+}
+
+// errNoPublicKey is returned for symmetric keys, which have no public part.
+var errNoPublicKey = errors.WithMessage(syscall.ENODATA, "symmetric keys have no public key")
+
+// publicKeyFromJSONWebKey returns the RSA or EC public key of jwk.
+func publicKeyFromJSONWebKey(jwk *azkeys.JSONWebKey) (crypto.PublicKey, error) {
+	if jwk == nil || jwk.Kty == nil {
+		return nil, errors.New("key has no type")
+	}
+	switch *jwk.Kty {
+	case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+		if len(jwk.N) == 0 || len(jwk.E) == 0 {
+			return nil, errors.New("RSA key has no modulus or exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(jwk.N),
+			E: int(new(big.Int).SetBytes(jwk.E).Int64()),
+		}, nil
+	case azkeys.KeyTypeEC, azkeys.KeyTypeECHSM:
+		for curve, name := range curveNames {
+			if jwk.Crv != nil && name == *jwk.Crv {
+				return &ecdsa.PublicKey{
+					Curve: curve,
+					X:     new(big.Int).SetBytes(jwk.X),
+					Y:     new(big.Int).SetBytes(jwk.Y),
+				}, nil
+			}
+		}
+		return nil, errors.New("unsupported curve")
+	case azkeys.KeyTypeOct, azkeys.KeyTypeOctHSM:
+		return nil, errNoPublicKey
+	default:
+		return nil, errors.Errorf("unsupported key type %s", *jwk.Kty)
+	}
+}
+
+// privateKeyFromJSONWebKey is the inverse of jsonWebKeyFromPrivate.
+func privateKeyFromJSONWebKey(jwk *azkeys.JSONWebKey) (crypto.PrivateKey, error) {
+	if jwk.Kty == nil || len(jwk.D) == 0 {
+		return nil, errors.New("key has no private part")
+	}
+	public, err := publicKeyFromJSONWebKey(jwk)
+	if err != nil {
+		return nil, err
+	}
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		key := &rsa.PrivateKey{
+			PublicKey: *public,
+			D:         new(big.Int).SetBytes(jwk.D),
+			Primes:    []*big.Int{new(big.Int).SetBytes(jwk.P), new(big.Int).SetBytes(jwk.Q)},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid RSA key")
+		}
+		key.Precompute()
+		return key, nil
+	case *ecdsa.PublicKey:
+		return &ecdsa.PrivateKey{PublicKey: *public, D: new(big.Int).SetBytes(jwk.D)}, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %s", *jwk.Kty)
+	}
+}
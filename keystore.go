@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"strings"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"github.com/pkg/errors"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// keystoreConfig says where the password of the .p12 and .jks files of a
+// certificate comes from: a tag on the certificate, or else a secret in the
+// same vault.
+type keystoreConfig struct {
+	PasswordTag    string `yaml:"password_tag"`
+	PasswordSecret string `yaml:"password_secret"`
+}
+
+var keystores = keystoreConfig{
+	PasswordTag: "keystore-password",
+}
+
+// keystoreSource is what a keystore file is assembled from.
+type keystoreSource struct {
+	alias    string
+	bundle   *certificateBundle
+	password string
+}
+
+// fetchKeystoreSource retrieves a certificate's key and chain along with the
+// keystore password.
+func fetchKeystoreSource(ctx context.Context, backend Backend, name string) (*keystoreSource, error) {
+	password, err := keystorePassword(ctx, backend, name)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := fetchCertificateBundle(ctx, backend, name)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.key == nil {
+		return nil, errKeyNotExportable
+	}
+	return &keystoreSource{alias: name, bundle: bundle, password: password}, nil
+}
+
+// keystorePassword returns the password of a certificate's keystores. The
+// password secret is read from the vault serving the certificate, and a
+// trailing newline, as left by editors and echo, is not part of it.
+func keystorePassword(ctx context.Context, backend Backend, name string) (string, error) {
+	source, err := sourceBackend(ctx, backend, certificateKind, name)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get certificate")
+	}
+	if len(keystores.PasswordTag) != 0 {
+		certificate, err := source.GetCertificate(ctx, name)
+		if err != nil {
+			return "", errors.Wrap(err, "could not get certificate")
+		}
+		if password := certificate.Tags[keystores.PasswordTag]; password != nil {
+			return *password, nil
+		}
+	}
+	if len(keystores.PasswordSecret) != 0 {
+		secret, err := source.GetSecret(ctx, keystores.PasswordSecret)
+		if err != nil {
+			return "", errors.Wrap(err, "could not get the keystore password secret")
+		}
+		return strings.TrimRight(string(secretValue(secret)), "\r\n"), nil
+	}
+	return "", errors.WithMessagef(syscall.ENODATA,
+		"no keystore password for %s: tag it with %q or configure a password secret", name, keystores.PasswordTag)
+}
+
+// redactedPassword replaces the keystore password tag in .response files.
+const redactedPassword = "(redacted)"
+
+// redactKeystorePassword returns certificate with the value of the keystore
+// password tag replaced, so that the password is only found in the keystores
+// it protects.
+func redactKeystorePassword(certificate azcertificates.Certificate) azcertificates.Certificate {
+	if len(keystores.PasswordTag) == 0 || certificate.Tags[keystores.PasswordTag] == nil {
+		return certificate
+	}
+	tags := make(map[string]*string, len(certificate.Tags))
+	for name, value := range certificate.Tags {
+		tags[name] = value
+	}
+	redacted := redactedPassword
+	tags[keystores.PasswordTag] = &redacted
+	certificate.Tags = tags
+	return certificate
+}
+
+// issuers returns the chain of the keystore without the leaf.
+func (source *keystoreSource) issuers() []*x509.Certificate {
+	return source.bundle.fullChain()[1:]
+}
+
+// pkcs12 encodes the key and chain as a PKCS#12 file.
+func (source *keystoreSource) pkcs12() ([]byte, error) {
+	data, err := pkcs12.Modern.Encode(source.bundle.key, source.bundle.leaf, source.issuers(), source.password)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode PKCS#12")
+	}
+	return data, nil
+}
+
+// jks encodes the key and chain as a Java keystore with a single entry
+// named after the certificate.
+func (source *keystoreSource) jks() ([]byte, error) {
+	key, err := x509.MarshalPKCS8PrivateKey(source.bundle.key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode private key")
+	}
+	chain := []keystore.Certificate{{Type: "X509", Content: source.bundle.leaf.Raw}}
+	for _, issuer := range source.issuers() {
+		chain = append(chain, keystore.Certificate{Type: "X509", Content: issuer.Raw})
+	}
+
+	store := keystore.New()
+	password := []byte(source.password)
+	err = store.SetPrivateKeyEntry(source.alias, keystore.PrivateKeyEntry{
+		// Fixed, so that the file has the same size every time it is built
+		CreationTime:     source.bundle.leaf.NotBefore,
+		PrivateKey:       key,
+		CertificateChain: chain,
+	}, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not add the key to the keystore")
+	}
+	var data bytes.Buffer
+	if err := store.Store(&data, password); err != nil {
+		return nil, errors.Wrap(err, "could not encode the keystore")
+	}
+	return data.Bytes(), nil
+}
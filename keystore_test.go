@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func Test_keystoreViews(t *testing.T) {
+	ctx := context.Background()
+	_, intermediate, leaf := testHierarchy(t)
+	keyPEM, err := marshalPrivateKeyPEM(leaf.key)
+	require.NoError(t, err)
+	value := append(append(keyPEM, encodeCertificate(leaf.certificate.Raw)...),
+		encodeCertificate(intermediate.certificate.Raw)...)
+	encoded := base64.StdEncoding.EncodeToString(value)
+
+	backend, err := NewLocalBackend(t.TempDir())
+	require.NoError(t, err)
+	password := "tagged"
+	for name, tags := range map[string]map[string]*string{
+		"tagged":   {"keystore-password": &password},
+		"untagged": nil,
+	} {
+		_, err = backend.ImportCertificate(ctx, name, azcertificates.ImportCertificateParameters{
+			Base64EncodedCertificate: &encoded,
+			Tags:                     tags,
+		})
+		require.NoError(t, err)
+	}
+
+	render := func(suffix, name string) ([]byte, error) {
+		v := viewFor(certificateKind, suffix)
+		object, err := v.fetch(ctx, backend, name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := v.render(ctx, object)
+		require.NoError(t, err)
+		size, err := v.size(ctx, object)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), size, "the size does not depend on random salts")
+		return data, nil
+	}
+
+	data, err := render(".p12", "tagged")
+	require.NoError(t, err)
+	key, certificate, chain, err := pkcs12.DecodeChain(data, "tagged")
+	require.NoError(t, err)
+	assert.True(t, publicKeyMatches(key, certificate))
+	assert.Equal(t, leaf.certificate.Raw, certificate.Raw)
+	require.Len(t, chain, 1)
+	assert.Equal(t, intermediate.certificate.Raw, chain[0].Raw)
+
+	data, err = render(".jks", "tagged")
+	require.NoError(t, err)
+	store := keystore.New()
+	require.NoError(t, store.Load(bytes.NewReader(data), []byte("tagged")))
+	entry, err := store.GetPrivateKeyEntry("tagged", []byte("tagged"))
+	require.NoError(t, err)
+	assert.Len(t, entry.CertificateChain, 2)
+
+	_, err = render(".p12", "untagged")
+	assert.Equal(t, syscall.ENODATA, errnoFor(err), "keystores need a password")
+
+	original := keystores
+	t.Cleanup(func() { keystores = original })
+	keystores.PasswordSecret = "keystore-password"
+	secret := "from secret\n"
+	_, err = backend.SetSecret(ctx, "keystore-password", azsecrets.SetSecretParameters{Value: &secret})
+	require.NoError(t, err)
+	data, err = render(".p12", "untagged")
+	require.NoError(t, err)
+	_, _, _, err = pkcs12.DecodeChain(data, "from secret")
+	assert.NoError(t, err, "without the trailing newline")
+}
+
+func Test_keystorePassword_overlay(t *testing.T) {
+	ctx := context.Background()
+	_, _, leaf := testHierarchy(t)
+	encoded := base64.StdEncoding.EncodeToString(encodeCertificate(leaf.certificate.Raw))
+	original := keystores
+	t.Cleanup(func() { keystores = original })
+	keystores.PasswordSecret = "keystore-password"
+
+	prod, baseline := newTestVault(t), newTestVault(t)
+	setTestSecret(t, prod, "keystore-password", "prod password")
+	setTestSecret(t, baseline, "keystore-password", "baseline password")
+	_, err := baseline.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &encoded,
+	})
+	require.NoError(t, err)
+
+	overlay := newOverlayBackend([]overlayLayer{{"prod", prod}, {"baseline", baseline}})
+	password, err := keystorePassword(ctx, overlay, "web")
+	require.NoError(t, err)
+	assert.Equal(t, "baseline password", password, "the secret comes from the certificate's vault")
+}
+
+func Test_keystoreViews_noPassword(t *testing.T) {
+	ctx := context.Background()
+	_, _, leaf := testHierarchy(t)
+	keyPEM, err := marshalPrivateKeyPEM(leaf.key)
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(append(keyPEM, encodeCertificate(leaf.certificate.Raw)...))
+	backend := newTestVault(t)
+	password := "hunter2"
+	for name, tags := range map[string]map[string]*string{
+		"tagged":   {"keystore-password": &password, "owner": to("platform")},
+		"untagged": nil,
+	} {
+		_, err = backend.ImportCertificate(ctx, name, azcertificates.ImportCertificateParameters{
+			Base64EncodedCertificate: &encoded,
+			Tags:                     tags,
+		})
+		require.NoError(t, err)
+	}
+	certificates, err := newTestRoot(backend).Find(certificatesDirName, ctx)
+	require.NoError(t, err)
+
+	for _, name := range []string{"untagged.p12", "untagged.jks"} {
+		entry, err := certificates.Find(name, ctx)
+		require.NoError(t, err)
+		require.NotNil(t, entry, name)
+		var attr fuse.Attr
+		require.NoError(t, File{entry}.Attr(ctx, &attr), "%s can be listed without a password", name)
+		assert.Zero(t, attr.Size)
+		_, err = File{entry}.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Equal(t, syscall.ENODATA, errnoFor(err), "opening %s tells why it is empty", name)
+	}
+
+	entry, err := certificates.Find("tagged.response", ctx)
+	require.NoError(t, err)
+	data, err := entry.Download(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), password)
+	var certificate azcertificates.Certificate
+	require.NoError(t, json.Unmarshal(data, &certificate))
+	assert.Equal(t, redactedPassword, *certificate.Tags["keystore-password"])
+	assert.Equal(t, "platform", *certificate.Tags["owner"], "other tags are kept")
+}
@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
-	"io"
 	"log"
 	"os"
+	"path"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -16,22 +14,54 @@ import (
 	"github.com/pkg/errors"
 )
 
-const cooldownTime = 5 * time.Second
+// cooldownTime is how long a directory listing is served before it is
+// retrieved again.
+var cooldownTime = 5 * time.Second
 
 const certificatesDirName = "certificates"
 const keysDirName = "keys"
 const secretsDirName = "secrets"
 
-type entryType int
+// directoryLayout names the directories in the root of the mount. A
+// directory with an empty name is not shown.
+type directoryLayout struct {
+	Certificates string `yaml:"certificates"`
+	Keys         string `yaml:"keys"`
+	Secrets      string `yaml:"secrets"`
+}
 
-const (
-	certificateEntryType entryType = iota + 1
-	keyEntryType
-	secretEntryType
-	keyResponseEntryType
-	certificateResponseEntryType
-	secretResponseEntryType
-)
+var layout = directoryLayout{
+	Certificates: certificatesDirName,
+	Keys:         keysDirName,
+	Secrets:      secretsDirName,
+}
+
+// objectFilter selects the objects that are shown by their name, using
+// path.Match patterns. Objects are shown if they match any of Include, or
+// Include is empty, and match none of Exclude.
+type objectFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+var filters objectFilter
+
+func (filter objectFilter) allows(name string) bool {
+	for _, pattern := range filter.Exclude {
+		if matched, _ := path.Match(pattern, name); matched {
+			return false
+		}
+	}
+	if len(filter.Include) == 0 {
+		return true
+	}
+	for _, pattern := range filter.Include {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
 
 type listingEntry struct {
 	name     string
@@ -39,12 +69,29 @@ type listingEntry struct {
 	modTime  time.Time
 	inode    uint64
 
-	vaultClients *AzKVClients
-	parent       *listingEntry
-	children     []*listingEntry
-	root         *listingEntry
-	isRoot       bool
-	entryType    entryType
+	backend Backend
+	parent  *listingEntry
+	root    *listingEntry
+	isRoot  bool
+
+	// childrenMutex guards children and objectCount, which a listing
+	// replaces while other requests read them.
+	childrenMutex sync.Mutex
+	children      []*listingEntry
+	// objectCount is the number of objects in the last listing of a
+	// directory of a kind, each of which has several files.
+	objectCount int
+
+	// isVault is set for the directories of vaults mounted side by side
+	// under one root, which contain the object directories.
+	isVault bool
+	// kind is set for the directories listing objects of that kind.
+	kind objectKind
+	// view is set for files and determines their contents.
+	view view
+	// fetches is the cache files fetch their objects through, shared by the
+	// files of a listing.
+	fetches *fetchCache
 
 	fetchTime *time.Time
 
@@ -52,10 +99,15 @@ type listingEntry struct {
 
 	nextInode *atomic.Uint64
 
-	filter     func(typ string, data []byte) []byte
-	filterType string
-
-	isCertChain bool
+	// handles are the open handles of a file, oldest first. Each holds the
+	// contents downloaded when it was opened.
+	handlesMutex sync.Mutex
+	handles      []*FileHandle
+	// lastSize is the size of the contents last opened or rendered at
+	// sizeTime, if hasLastSize.
+	lastSize    int64
+	sizeTime    time.Time
+	hasLastSize bool
 }
 
 var (
@@ -63,19 +115,39 @@ var (
 )
 
 func (entry *listingEntry) IsDir() bool {
-	return entry.isRoot || entry.parent.isRoot
+	return entry.view == nil
 }
 
-func (entry *listingEntry) isCertificatesDir() bool {
-	return entry.parent.isRoot && entry.name == certificatesDirName
-}
-
-func (entry *listingEntry) isSecretsDir() bool {
-	return entry.parent.isRoot && entry.name == secretsDirName
+// newRootEntry creates the root of a mount. With a backend, the root shows
+// the object directories of that vault; without, vaults are added with
+// addVault.
+func newRootEntry(backend Backend) *listingEntry {
+	root := &listingEntry{
+		name:      "root",
+		modTime:   time.Now(),
+		inode:     1,
+		isRoot:    true,
+		backend:   backend,
+		nextInode: &atomic.Uint64{},
+	}
+	root.root = root
+	root.nextInode.Add(root.inode)
+	return root
 }
 
-func (entry *listingEntry) isKeysDir() bool {
-	return entry.parent.isRoot && entry.name == keysDirName
+// addVault adds a vault as a directory of the root.
+func (entry *listingEntry) addVault(name string, backend Backend) {
+	entry.childrenMutex.Lock()
+	defer entry.childrenMutex.Unlock()
+	entry.children = append(entry.children, &listingEntry{
+		name:    name,
+		modTime: time.Now(),
+		inode:   entry.advanceInode(),
+		backend: backend,
+		parent:  entry,
+		root:    entry.root,
+		isVault: true,
+	})
 }
 
 func (entry *listingEntry) retrieveDirectoryListing(ctx context.Context) error {
@@ -83,278 +155,199 @@ func (entry *listingEntry) retrieveDirectoryListing(ctx context.Context) error {
 		return nil
 	}
 	log.Println("Retrieving directory listing for", entry.name, "inode", entry.inode)
-	if entry.isRoot {
+	if entry.isRoot || entry.isVault {
+		entry.childrenMutex.Lock()
+		defer entry.childrenMutex.Unlock()
 		if len(entry.children) > 0 {
 			return nil
 		} else {
 			now := time.Now()
-			entry.children = []*listingEntry{
-				{
-					name:         certificatesDirName,
-					modTime:      now,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					root:         entry.root,
-					fetchTime:    nil,
-				},
-				{
-					name:         keysDirName,
-					modTime:      now,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					root:         entry.root,
-					fetchTime:    nil,
-				},
-				{
-					name:         secretsDirName,
-					modTime:      now,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					root:         entry.root,
-					fetchTime:    nil,
-				},
+			directories := []struct {
+				name string
+				kind objectKind
+			}{
+				{layout.Certificates, certificateKind},
+				{layout.Keys, keyKind},
+				{layout.Secrets, secretKind},
+			}
+			hsm, ok := entry.backend.(managedHSM)
+			isHSM := ok && hsm.IsManagedHSM()
+			for _, directory := range directories {
+				if len(directory.name) == 0 || (isHSM && directory.kind != keyKind) {
+					continue
+				}
+				entry.children = append(entry.children, &listingEntry{
+					name:      directory.name,
+					kind:      directory.kind,
+					modTime:   now,
+					inode:     entry.advanceInode(),
+					backend:   entry.backend,
+					parent:    entry,
+					root:      entry.root,
+					fetchTime: nil,
+				})
+			}
+			if isHSM {
+				entry.children = append(entry.children, &listingEntry{
+					name:    randomFileName,
+					modTime: now,
+					inode:   entry.advanceInode(),
+					backend: entry.backend,
+					parent:  entry,
+					root:    entry.root,
+					view:    randomView{},
+				})
 			}
 			return nil
 		}
 	}
-	switch {
-	case entry.isSecretsDir():
-		return entry.retrieveSecretsDirectoryListing(ctx)
-	case entry.isCertificatesDir():
-		return entry.retrieveCertificatesDirectoryListing(ctx)
-	case entry.isKeysDir():
-		return entry.retrieveKeysDirectoryListing(ctx)
-	default:
+	if entry.kind == 0 {
 		return errors.New("Directory is untracked")
 	}
+	err := entry.retrieveObjectListing(ctx)
+	if err != nil && errors.Is(err, errCircuitOpen) && entry.fetchTime != nil {
+		// Key Vault is unavailable, keep serving the last listing
+		log.Println("Serving cached listing for", entry.name, "from", entry.fetchTime.Format(time.RFC3339))
+		return nil
+	}
+	return err
 }
 
-func (entry *listingEntry) retrieveKeysDirectoryListing(ctx context.Context) error {
-	pager := entry.vaultClients.keys.NewListKeyPropertiesPager(nil)
-	entry.children = nil
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return errors.Wrap(err, "could not get next page for secrets")
-		}
-		for _, key := range page.Value {
-			modTime := time.UnixMilli(0)
-			if key.Attributes.Updated != nil {
-				modTime = *key.Attributes.Updated
-			} else if key.Attributes.Created != nil {
-				modTime = *key.Attributes.Created
+// retrieveObjectListing lists the objects of the directory's kind and
+// creates one file per object and view offered for it.
+func (entry *listingEntry) retrieveObjectListing(ctx context.Context) error {
+	objects, err := entry.backend.List(ctx, entry.kind)
+	if err != nil {
+		return err
+	}
+	var children []*listingEntry
+	fetches := newFetchCache(entry.backend)
+	objectCount := 0
+	for _, object := range objects {
+		if !filters.allows(object.name) {
+			continue
+		}
+		objectCount++
+		for _, v := range viewsFor(entry.kind) {
+			if !v.offered(object) {
+				continue
 			}
-			entry.children = append(entry.children,
-				&listingEntry{
-					name:         key.KID.Name(),
-					azKvName:     key.KID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    keyEntryType,
-				},
-				&listingEntry{
-					name:         key.KID.Name() + ".pem",
-					azKvName:     key.KID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    keyEntryType,
-					filter:       ConvertEntry,
-					filterType:   pemPrivKeyType,
-				},
-				&listingEntry{
-					name:         key.KID.Name() + ".response",
-					azKvName:     key.KID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    keyResponseEntryType,
-				},
-			)
+			children = append(children, &listingEntry{
+				name:      object.name + v.suffix(),
+				azKvName:  object.name,
+				modTime:   object.modTime,
+				inode:     entry.advanceInode(),
+				backend:   entry.backend,
+				parent:    entry,
+				children:  nil,
+				fetchTime: nil,
+				root:      entry.root,
+				view:      v,
+				fetches:   fetches,
+			})
 		}
 	}
+	entry.childrenMutex.Lock()
+	entry.children = children
+	entry.objectCount = objectCount
+	entry.childrenMutex.Unlock()
 	now := time.Now()
 	entry.fetchTime = &now
 	return nil
 }
 
-func (entry *listingEntry) retrieveCertificatesDirectoryListing(ctx context.Context) error {
-	pager := entry.vaultClients.certificates.NewListCertificatePropertiesPager(nil)
-	entry.children = nil
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return errors.Wrap(err, "could not get next page for secrets")
-		}
-		for _, certificate := range page.Value {
-			modTime := time.UnixMilli(0)
-			if certificate.Attributes.Updated != nil {
-				modTime = *certificate.Attributes.Updated
-			} else if certificate.Attributes.Created != nil {
-				modTime = *certificate.Attributes.Created
-			}
-			entry.children = append(entry.children,
-				&listingEntry{
-					name:         certificate.ID.Name(),
-					azKvName:     certificate.ID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    certificateEntryType,
-				},
-				&listingEntry{
-					name:         certificate.ID.Name() + ".pem",
-					azKvName:     certificate.ID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    certificateEntryType,
-					filter:       ConvertEntry,
-					filterType:   pemCertType,
-				},
-				&listingEntry{
-					name:         certificate.ID.Name() + ".chain.pem",
-					azKvName:     certificate.ID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    certificateEntryType,
-					isCertChain:  true,
-				},
-				&listingEntry{
-					name:         certificate.ID.Name() + ".response",
-					azKvName:     certificate.ID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    certificateResponseEntryType,
-				},
-			)
+func (entry *listingEntry) advanceInode() uint64 {
+	return entry.root.nextInode.Add(1)
+}
+
+// addHandle registers a handle opened on the file.
+func (entry *listingEntry) addHandle(handle *FileHandle) {
+	entry.handlesMutex.Lock()
+	defer entry.handlesMutex.Unlock()
+	entry.handles = append(entry.handles, handle)
+	entry.setLastSize(int64(len(handle.data)))
+}
+
+// setLastSize remembers the size of the contents. The caller holds
+// handlesMutex.
+func (entry *listingEntry) setLastSize(size int64) {
+	entry.lastSize = size
+	entry.sizeTime = time.Now()
+	entry.hasLastSize = true
+}
+
+// removeHandle forgets a handle once it is released.
+func (entry *listingEntry) removeHandle(handle *FileHandle) {
+	entry.handlesMutex.Lock()
+	defer entry.handlesMutex.Unlock()
+	for i, open := range entry.handles {
+		if open == handle {
+			entry.handles = append(entry.handles[:i], entry.handles[i+1:]...)
+			return
 		}
 	}
-	now := time.Now()
-	entry.fetchTime = &now
-	return nil
 }
 
-func (entry *listingEntry) retrieveSecretsDirectoryListing(ctx context.Context) error {
-	pager := entry.vaultClients.secrets.NewListSecretPropertiesPager(nil)
-	entry.children = nil
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return errors.Wrap(err, "could not get next page for secrets")
-		}
-		for _, secret := range page.Value {
-			modTime := time.UnixMilli(0)
-			if secret.Attributes.Updated != nil {
-				modTime = *secret.Attributes.Updated
-			} else if secret.Attributes.Created != nil {
-				modTime = *secret.Attributes.Created
-			}
-			entry.children = append(entry.children,
-				&listingEntry{
-					name:         secret.ID.Name(),
-					azKvName:     secret.ID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    secretEntryType,
-				},
-				&listingEntry{
-					name:         secret.ID.Name() + ".response",
-					azKvName:     secret.ID.Name(),
-					modTime:      modTime,
-					inode:        entry.advanceInode(),
-					vaultClients: entry.vaultClients,
-					parent:       entry,
-					children:     nil,
-					fetchTime:    nil,
-					root:         entry.root,
-					entryType:    secretResponseEntryType,
-				},
-			)
-
-			if secret.ContentType != nil && *secret.ContentType == "application/x-pkcs12" {
-				// Provide the base64-decoded value as .pfx file
-				entry.children = append(entry.children,
-					&listingEntry{
-						name:         secret.ID.Name() + ".pfx",
-						azKvName:     secret.ID.Name(),
-						modTime:      modTime,
-						inode:        entry.advanceInode(),
-						vaultClients: entry.vaultClients,
-						parent:       entry,
-						children:     nil,
-						fetchTime:    nil,
-						root:         entry.root,
-						entryType:    secretEntryType,
-						filter:       ConvertEntry,
-						filterType:   base64PfxType,
-					},
-				)
-			}
-		}
+// snapshotSize returns the size of the contents seen by the oldest open
+// handle, if there is any. Reporting the size of a later Open would cut
+// short or pad the reads of handles opened before it.
+func (entry *listingEntry) snapshotSize() (int64, bool) {
+	entry.handlesMutex.Lock()
+	defer entry.handlesMutex.Unlock()
+	if len(entry.handles) == 0 {
+		return 0, false
 	}
-	now := time.Now()
-	entry.fetchTime = &now
-	return nil
+	return int64(len(entry.handles[0].data)), true
 }
 
-func (entry *listingEntry) advanceInode() uint64 {
-	return entry.root.nextInode.Add(1)
+// cachedSize returns the size of the contents from the last time the file
+// was opened or its size determined, or zero if neither has happened yet.
+func (entry *listingEntry) cachedSize() (int64, bool) {
+	entry.handlesMutex.Lock()
+	defer entry.handlesMutex.Unlock()
+	return entry.lastSize, entry.hasLastSize
 }
 
-func (entry *listingEntry) Find(name string, ctx context.Context) *listingEntry {
+// childEntries returns the entries of the last listing of a directory.
+func (entry *listingEntry) childEntries() []*listingEntry {
+	entry.childrenMutex.Lock()
+	defer entry.childrenMutex.Unlock()
+	return entry.children
+}
+
+// countObjects returns the number of vault objects below entry, based on
+// the listings retrieved so far. Directories and the several files of each
+// object are not counted.
+func (entry *listingEntry) countObjects() uint64 {
+	entry.childrenMutex.Lock()
+	count := uint64(entry.objectCount)
+	children := entry.children
+	entry.childrenMutex.Unlock()
+	if entry.kind != 0 {
+		return count
+	}
+	for _, child := range children {
+		if child.IsDir() {
+			count += child.countObjects()
+		}
+	}
+	return count
+}
+
+func (entry *listingEntry) Find(name string, ctx context.Context) (*listingEntry, error) {
 	log.Println("Find", name, "in", entry.name, "inode", entry.inode)
 	if entry.fetchTime == nil {
 		err := entry.retrieveDirectoryListing(ctx)
 		if err != nil {
-			return nil
+			return nil, err
 		}
 	}
-	for _, child := range entry.children {
+	for _, child := range entry.childEntries() {
 		if child.name == name {
-			return child
+			return child, nil
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 func encodeCertificate(der []byte) []byte {
@@ -364,221 +357,80 @@ func encodeCertificate(der []byte) []byte {
 	})
 }
 
-func buildCertificateChain(der []byte) ([][]byte, error) {
-	var chain [][]byte
-	cert, _ := x509.ParseCertificate(der)
-	if cert != nil {
-		chain = append(chain, encodeCertificate(der))
-		for _, url := range cert.IssuingCertificateURL {
-			log.Println("Found intermediate, downloading:", url)
-			resp, err := httpClient.Get(url)
-			if err != nil {
-				return nil, err
-			}
-			intermediateCertBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return nil, err
-			}
-			_ = resp.Body.Close()
-
-			cert, _ := x509.ParseCertificate(der)
-			if cert != nil {
-				if len(cert.IssuingCertificateURL) != 0 {
-					parentChain, err := buildCertificateChain(intermediateCertBytes)
-					if err != nil {
-						return nil, err
-					}
-					chain = append(chain, parentChain...)
-				}
-			}
-		}
+func (entry *listingEntry) Download(ctx context.Context) ([]byte, error) {
+	log.Println("Download file", entry.name, "inode", entry.inode)
+	if entry.view == nil {
+		return nil, errors.New("not a file")
 	}
-	return chain, nil
-}
-
-func (entry *listingEntry) certChain(data []byte) ([]byte, error) {
-	chain, err := buildCertificateChain(data)
+	object, err := entry.view.fetch(ctx, entry.objectBackend(), entry.azKvName)
 	if err != nil {
 		return nil, err
 	}
-	var chainBytes bytes.Buffer
-	// All except root certificate
-	for _, pemCert := range chain[:len(chain)-1] {
-		chainBytes.Write(pemCert)
-	}
-	return chainBytes.Bytes(), nil
-}
-
-func (entry *listingEntry) Download(ctx context.Context) ([]byte, error) {
-	log.Println("Download file", entry.name, "inode", entry.inode)
-	var result []byte = nil
-	switch entry.entryType {
-	case certificateEntryType:
-		certificateResponse, err :=
-			entry.vaultClients.certificates.GetCertificate(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not get certificate")
-		}
-		result = certificateResponse.CER
-		if entry.isCertChain {
-			result, err = entry.certChain(result)
-			if err != nil {
-				return nil, err
-			}
-		}
-	case certificateResponseEntryType:
-		certificateResponse, err :=
-			entry.vaultClients.certificates.GetCertificate(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not get certificate")
-		}
-		// Marshal certificateResponse
-		result, err = json.Marshal(certificateResponse)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not marshal certificate response")
-		}
-	case keyEntryType:
-		keyResponse, err := entry.vaultClients.keys.GetKey(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not get key")
-		}
-		result = keyResponse.Key.K
-	case keyResponseEntryType:
-		keyResponse, err := entry.vaultClients.keys.GetKey(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not get key")
-		}
-		// Marshal keyResponse
-		result, err = json.Marshal(keyResponse)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not marshal key response")
-		}
-	case secretEntryType:
-		secretResponse, err := entry.vaultClients.secrets.GetSecret(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not get secret")
-		}
-		result = []byte(*secretResponse.Value)
-	case secretResponseEntryType:
-		secretResponse, err := entry.vaultClients.secrets.GetSecret(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not get secret")
-		}
-		// Marshal secretResponse
-		result, err = json.Marshal(secretResponse)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not marshal secret response")
-		}
+	result, err := entry.view.render(ctx, object)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not render %s", entry.name)
 	}
 
 	now := time.Now()
 	entry.fetchTime = &now
 
-	if entry.filter != nil {
-		return entry.filter(entry.filterType, result), nil
-	}
-
 	return result, nil
 }
 
+// Size implements os.FileInfo. Prefer sizeContext where a request context
+// is available, as this can only use the lookup timeout and cannot be
+// interrupted.
 func (entry *listingEntry) Size() int64 {
+	ctx, cancel := timeouts.lookupContext(context.Background())
+	defer cancel()
+	size, err := entry.sizeContext(ctx)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (entry *listingEntry) sizeContext(ctx context.Context) (int64, error) {
 	log.Println("Determining size of", entry.name, "inode", entry.inode)
 	if entry.IsDir() {
-		return int64(len(entry.children))
+		return int64(len(entry.childEntries())), nil
 	}
-	ctx := context.Background()
-
-	switch entry.entryType {
-	case certificateEntryType:
-		certificateResponse, err :=
-			entry.vaultClients.certificates.GetCertificate(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return -1
-		}
-
-		result := certificateResponse.CER
-
-		if entry.isCertChain {
-			result, err = entry.certChain(result)
-			if err != nil {
-				return -1
-			}
-		}
-
-		if entry.filter != nil {
-			return int64(len(entry.filter(entry.filterType, result)))
-		} else {
-			return int64(len(result))
-		}
-	case certificateResponseEntryType:
-		certificateResponse, err :=
-			entry.vaultClients.certificates.GetCertificate(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return -1
-		}
+	if entry.view == nil {
+		return -1, errors.New("not a file")
+	}
+	if size, ok := entry.recentSize(); ok {
+		return size, nil
+	}
+	object, err := entry.view.fetch(ctx, entry.objectBackend(), entry.azKvName)
+	if err != nil {
+		return -1, err
+	}
+	size, err := entry.view.size(ctx, object)
+	if err != nil {
+		return -1, err
+	}
+	entry.handlesMutex.Lock()
+	entry.setLastSize(size)
+	entry.handlesMutex.Unlock()
+	return size, nil
+}
 
-		// Marshal certificateResponse
-		result, err := json.Marshal(certificateResponse)
-		if err != nil {
-			return -1
-		}
+// recentSize returns the size determined within cooldownTime, so that
+// rendering a file, which may download the issuers of a certificate, is
+// not repeated for every stat.
+func (entry *listingEntry) recentSize() (int64, bool) {
+	entry.handlesMutex.Lock()
+	defer entry.handlesMutex.Unlock()
+	if !entry.hasLastSize || time.Since(entry.sizeTime) >= cooldownTime {
+		return 0, false
+	}
+	return entry.lastSize, true
+}
 
-		if entry.filter != nil {
-			return int64(len(entry.filter(entry.filterType, result)))
-		} else {
-			return int64(len(result))
-		}
-	case keyEntryType:
-		keyResponse, err := entry.vaultClients.keys.GetKey(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return -1
-		}
-		if entry.filter != nil {
-			return int64(len(entry.filter(entry.filterType, keyResponse.Key.K)))
-		} else {
-			return int64(len(keyResponse.Key.K))
-		}
-	case keyResponseEntryType:
-		keyResponse, err := entry.vaultClients.keys.GetKey(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return -1
-		}
-		// Marshal keyResponse
-		result, err := json.Marshal(keyResponse)
-		if err != nil {
-			return -1
-		}
-		if entry.filter != nil {
-			return int64(len(entry.filter(entry.filterType, result)))
-		} else {
-			return int64(len(result))
-		}
-	case secretEntryType:
-		secretResponse, err := entry.vaultClients.secrets.GetSecret(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return -1
-		}
-		if entry.filter != nil {
-			return int64(len(entry.filter(entry.filterType, []byte(*secretResponse.Value))))
-		} else {
-			return int64(len([]byte(*secretResponse.Value)))
-		}
-	case secretResponseEntryType:
-		secretResponse, err := entry.vaultClients.secrets.GetSecret(ctx, entry.azKvName, "", nil)
-		if err != nil {
-			return -1
-		}
-		// Marshal secretResponse
-		result, err := json.Marshal(secretResponse)
-		if err != nil {
-			return -1
-		}
-		if entry.filter != nil {
-			return int64(len(entry.filter(entry.filterType, result)))
-		} else {
-			return int64(len(result))
-		}
-	default:
-		return -1
+// objectBackend returns the backend a file fetches its object from.
+func (entry *listingEntry) objectBackend() Backend {
+	if entry.fetches != nil {
+		return entry.fetches
 	}
+	return entry.backend
 }
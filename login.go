@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/public"
+	"github.com/pkg/errors"
+)
+
+// developerClientID is the public client the Azure CLI and azidentity sign
+// developers in with, used when no application is configured.
+const developerClientID = "04b07795-8ddb-461a-bbee-02f9e1bf7b46"
+
+// isInteractive reports whether a credential type signs the user in.
+func isInteractive(credentialType string) bool {
+	return credentialType == "device-code" || credentialType == "browser"
+}
+
+// interactiveCredential signs a user in with a device code or a browser and
+// keeps the tokens in a tokenCache, so that later mounts can refresh them
+// silently.
+type interactiveCredential struct {
+	client   public.Client
+	browser  bool
+	tenantID string
+
+	// mutex makes concurrent requests wait for one sign-in instead of
+	// prompting several times.
+	mutex sync.Mutex
+}
+
+// transportHTTPClient lets MSAL send its requests through a Transporter.
+type transportHTTPClient struct {
+	policy.Transporter
+}
+
+func (transportHTTPClient) CloseIdleConnections() {}
+
+func newInteractiveCredential(credentials credentialConfig, cloud cloudConfig, transport policy.Transporter) (*interactiveCredential, error) {
+	clientID := credentials.ClientID
+	if len(clientID) == 0 {
+		clientID = developerClientID
+	}
+	tenantID := credentials.TenantID
+	if len(tenantID) == 0 {
+		tenantID = "organizations"
+	}
+
+	tokens := &tokenCache{file: credentials.TokenCache, keyFile: credentials.TokenCache + ".key"}
+	if len(credentials.TokenCache) == 0 {
+		var err error
+		if tokens, err = defaultTokenCache(); err != nil {
+			return nil, err
+		}
+	}
+
+	options := []public.Option{
+		public.WithAuthority(strings.TrimSuffix(cloud.resolved().AuthorityHost, "/") + "/" + tenantID),
+		public.WithCache(tokens),
+	}
+	if transport != nil {
+		options = append(options, public.WithHTTPClient(transportHTTPClient{transport}))
+	}
+	client, err := public.New(clientID, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &interactiveCredential{
+		client:   client,
+		browser:  credentials.Type == "browser",
+		tenantID: credentials.TenantID,
+	}, nil
+}
+
+// account returns the signed-in account from the token cache, if any.
+func (credential *interactiveCredential) account(ctx context.Context) (public.Account, bool) {
+	accounts, err := credential.client.Accounts(ctx)
+	if err != nil {
+		return public.Account{}, false
+	}
+	for _, account := range accounts {
+		if len(credential.tenantID) == 0 || strings.EqualFold(account.Realm, credential.tenantID) {
+			return account, true
+		}
+	}
+	return public.Account{}, false
+}
+
+func (credential *interactiveCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	credential.mutex.Lock()
+	defer credential.mutex.Unlock()
+
+	if account, found := credential.account(ctx); found {
+		result, err := credential.client.AcquireTokenSilent(ctx, options.Scopes, public.WithSilentAccount(account))
+		if err == nil {
+			return azcore.AccessToken{Token: result.AccessToken, ExpiresOn: result.ExpiresOn}, nil
+		}
+	}
+
+	var result public.AuthResult
+	var err error
+	if credential.browser {
+		fmt.Fprintln(os.Stderr, "Signing in to Azure in your browser...")
+		result, err = credential.client.AcquireTokenInteractive(ctx, options.Scopes,
+			public.WithRedirectURI("http://localhost"))
+	} else {
+		var deviceCode public.DeviceCode
+		deviceCode, err = credential.client.AcquireTokenByDeviceCode(ctx, options.Scopes)
+		if err == nil {
+			fmt.Fprintln(os.Stderr, deviceCode.Result.Message)
+			result, err = deviceCode.AuthenticationResult(ctx)
+		}
+	}
+	if err != nil {
+		return azcore.AccessToken{}, errors.Wrap(err, "sign-in failed")
+	}
+	return azcore.AccessToken{Token: result.AccessToken, ExpiresOn: result.ExpiresOn}, nil
+}
+
+// signIn makes sure the user is signed in for scope before mounting, as
+// file system requests cannot wait for the user to complete a sign-in.
+func signIn(credential azcore.TokenCredential, scope string) error {
+	_, err := credential.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{scope}})
+	return err
+}
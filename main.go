@@ -4,24 +4,33 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
-	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/pkg/errors"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 )
 
-var conn *fuse.Conn
-var mountDir string
+// mount is a file system mounted and served by this process.
+type mount struct {
+	dir    string
+	source string
+	root   *listingEntry
+	conn   *fuse.Conn
+}
+
+var mounts []*mount
 var isExiting = false
 
+// requestMetrics holds the request statistics of each vault by URL.
+var requestMetrics = map[string]*throttleMetrics{}
+
 func handleStopsAndCrashes() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan,
@@ -39,108 +48,253 @@ func handleStopsAndCrashes() {
 			os.Exit(1)
 		}
 		isExiting = true
-		var wg sync.WaitGroup
-		wg.Add(1)
-		go func() {
-			log.Println("Closing connection...")
-			err := conn.Close()
-			if err != nil {
-				log.Println("Error while closing connection")
-				log.Fatal(err)
+		logRequestMetrics()
+		failed := false
+		for _, m := range mounts {
+			if err := m.unmount(); err != nil {
+				log.Println("Error while unmounting", m.dir+":", err)
+				failed = true
 			}
-			log.Println("Connection closed.")
-			wg.Done()
-		}()
-		log.Println("Unmounting", mountDir)
-		err := fuse.Unmount(mountDir)
-		wg.Wait()
-		if err != nil {
-			log.Println("Error while exiting")
-			log.Fatal(err)
-		} else {
-			log.Println("Exiting.")
-			log.Println()
-			os.Exit(0)
 		}
+		if failed {
+			log.Fatal("Error while exiting")
+		}
+		log.Println("Exiting.")
+		log.Println()
+		os.Exit(0)
 	}()
 }
 
-func main() {
-	var err error
+// logRequestMetrics logs the request statistics of every vault.
+func logRequestMetrics() {
+	for source, metrics := range requestMetrics {
+		log.Println("Key Vault request statistics for", source+":", metrics)
+	}
+}
 
-	keyVaultURLParam := flag.String("url", "", "URL of Azure Key Vault")
-	flag.Parse()
-	mountDir = flag.Arg(0)
+// handleMetricsRequests logs the request statistics whenever SIGUSR1 is
+// received, so that throttling can be watched while mounted.
+func handleMetricsRequests() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1)
+	go func() {
+		for range sigChan {
+			logRequestMetrics()
+		}
+	}()
+}
 
-	if len(mountDir) == 0 {
-		usage()
-		return
+// unmount closes the FUSE connection and unmounts the file system.
+func (m *mount) unmount() error {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var closeErr error
+	go func() {
+		log.Println("Closing connection for", m.dir)
+		closeErr = m.conn.Close()
+		wg.Done()
+	}()
+	log.Println("Unmounting", m.dir)
+	err := fuse.Unmount(m.dir)
+	wg.Wait()
+	if err != nil {
+		return err
 	}
+	if closeErr != nil {
+		return errors.Wrap(closeErr, "could not close connection")
+	}
+	runHook("on_unmount", hooks.OnUnmount, m.dir, m.source)
+	return nil
+}
 
-	if !dirExists(mountDir) {
-		fmt.Println(fmt.Errorf("%s: not found or not a directory", mountDir))
-		os.Exit(int(syscall.ENOENT))
+// unmountAll unmounts the file systems mounted so far, so that none are left
+// behind when the process exits on an error.
+func unmountAll() {
+	for _, m := range mounts {
+		if m.conn == nil {
+			continue
+		}
+		if err := m.unmount(); err != nil {
+			log.Println("Error while unmounting", m.dir+":", err)
+		}
 	}
+}
 
-	if keyVaultURLParam == nil || len(*keyVaultURLParam) == 0 {
-		usage()
+// retryOptions configures the SDK retry policy. The SDK retries 3 times when
+// MaxRetries is 0, so no retries has to be passed as -1.
+func retryOptions(connection connectionConfig) policy.RetryOptions {
+	maxRetries := int32(connection.MaxRetries)
+	if maxRetries == 0 {
+		maxRetries = -1
+	}
+	return policy.RetryOptions{
+		MaxRetries:    maxRetries,
+		RetryDelay:    connection.RetryDelay,
+		MaxRetryDelay: connection.MaxRetryDelay,
+	}
+}
+
+// connectOptions configures the connection to a vault.
+func connectOptions(config *Config, vault vaultConfig) ConnectOptions {
+	connection := config.Connection
+	return ConnectOptions{
+		Credentials:       config.credentials(vault),
+		Cloud:             config.Cloud,
+		ManagedHSM:        vault.isManagedHSM(),
+		Transport:         httpClient,
+		Retry:             retryOptions(connection),
+		RequestsPerSecond: connection.RateLimit,
+		Burst:             connection.RateBurst,
+		BreakerThreshold:  connection.BreakerThreshold,
+		BreakerCooldown:   connection.BreakerCooldown,
+		// The emulator cannot be under the Key Vault DNS suffix
+		DisableChallengeResourceVerification: isLoopbackVault(vault.URL),
+	}
+}
+
+// openVault creates the backend of a vault. Every vault gets its own
+// clients and credential.
+func openVault(config *Config, vault vaultConfig) Backend {
+	if len(vault.Local) != 0 {
+		localBackend, err := NewLocalBackend(vault.Local)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return localBackend
+	}
+	azKvClient := ConnectToKeyVault(vault.URL, connectOptions(config, vault))
+	requestMetrics[vault.URL] = &azKvClient.throttle.metrics
+	return azKvClient
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "emulator" {
+		runEmulator(os.Args[2:])
 		return
 	}
-	keyVaultURL := *keyVaultURLParam
 
-	URL, err := url.Parse(keyVaultURL)
+	config, flags, err := parseConfig(os.Args[1:])
 	if err != nil {
-		fmt.Println(errors.Wrap(err, fmt.Sprintf("invalid URL \"%s\"", keyVaultURL)))
+		fmt.Println(err)
 		os.Exit(int(syscall.EINVAL))
 	}
 
-	azKvClient := ConnectToKeyVault(URL.String())
+	if len(config.Vaults) == 0 && len(config.URL) == 0 && len(config.Local) == 0 {
+		usage(flags)
+		return
+	}
+	plans := config.mountPlans()
+	for _, plan := range plans {
+		if len(plan.dir) == 0 {
+			usage(flags)
+			return
+		}
+	}
+	if err := config.validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(int(syscall.EINVAL))
+	}
+	if err := config.apply(); err != nil {
+		fmt.Println(err)
+		os.Exit(int(syscall.EINVAL))
+	}
 
-	root := listingEntry{
-		name:         "root",
-		modTime:      time.Now(),
-		inode:        1,
-		parent:       nil,
-		children:     nil,
-		isRoot:       true,
-		vaultClients: azKvClient,
-		nextInode:    &atomic.Uint64{},
+	for _, plan := range plans {
+		if !dirExists(plan.dir) {
+			fmt.Println(fmt.Errorf("%s: not found or not a directory", plan.dir))
+			os.Exit(int(syscall.ENOENT))
+		}
+	}
+
+	for _, plan := range plans {
+		m := &mount{dir: plan.dir}
+		if plan.shared && config.Overlay {
+			var layers []overlayLayer
+			var names []string
+			for _, vault := range plan.vaults {
+				layers = append(layers, overlayLayer{name: vault.Name, backend: openVault(&config, vault)})
+				names = append(names, vault.Name)
+			}
+			m.root = newRootEntry(newOverlayBackend(layers))
+			m.source = strings.Join(names, "+")
+		} else if plan.shared {
+			m.root = newRootEntry(nil)
+			var names []string
+			for _, vault := range plan.vaults {
+				m.root.addVault(vault.Name, openVault(&config, vault))
+				names = append(names, vault.Name)
+			}
+			m.source = strings.Join(names, ",")
+		} else {
+			m.root = newRootEntry(openVault(&config, plan.vaults[0]))
+			m.source = plan.vaults[0].source()
+		}
+		mounts = append(mounts, m)
 	}
-	root.root = &root
-	root.nextInode.Add(root.inode)
 
 	handleStopsAndCrashes()
+	handleMetricsRequests()
 	defer func() {
 		if r := recover(); r != nil {
-			_ = conn.Close()
-			_ = fuse.Unmount(mountDir)
+			for _, m := range mounts {
+				if m.conn != nil {
+					_ = m.conn.Close()
+					_ = fuse.Unmount(m.dir)
+				}
+			}
 			panic(r)
 		}
 	}()
 
-	log.Println("Mounting", keyVaultURL, "on", mountDir)
-	conn, err = fuse.Mount(
-		mountDir,
+	mountOptions := []fuse.MountOption{
 		fuse.FSName("azure-key-vault"),
 		fuse.Subtype("azkv"),
 		fuse.AllowNonEmptyMount(),
-	)
-	if err != nil {
-		log.Fatal(err)
 	}
-	defer conn.Close()
-
-	err = fs.Serve(conn, FS{
-		RootEntry: &Dir{
-			entry: &root,
-		},
-	})
-	if err != nil {
-		log.Fatal(err)
+	if permissions.AllowOther {
+		mountOptions = append(mountOptions, fuse.AllowOther())
+	}
+	for _, m := range mounts {
+		log.Println("Mounting", m.source, "on", m.dir)
+		m.conn, err = fuse.Mount(m.dir, mountOptions...)
+		if err != nil {
+			log.Println("Could not mount", m.dir+":", err)
+			unmountAll()
+			os.Exit(1)
+		}
+		defer m.conn.Close()
 	}
 
-	_ = root
-	_ = err
+	var wg sync.WaitGroup
+	var failure sync.Once
+	failed := false
+	for _, m := range mounts {
+		wg.Add(1)
+		go func(m *mount) {
+			defer wg.Done()
+			err := fs.Serve(m.conn, FS{
+				RootEntry: &Dir{
+					entry: m.root,
+				},
+			})
+			if err != nil {
+				log.Println("Error while serving", m.dir+":", err)
+				// Unmounting makes the other mounts stop serving as well
+				failure.Do(func() {
+					failed = true
+					isExiting = true
+					unmountAll()
+				})
+			}
+		}(m)
+		// Requests from the hook are only answered once serving has started
+		go runHook("on_mount", hooks.OnMount, m.dir, m.source)
+	}
+	wg.Wait()
+	if failed {
+		os.Exit(1)
+	}
 }
 
 func dirExists(path string) bool {
@@ -151,8 +305,9 @@ func dirExists(path string) bool {
 	return false
 }
 
-func usage() {
+func usage(flags *flag.FlagSet) {
 	fmt.Printf("Usage: %s [options...] <mount point>\n", os.Args[0])
-	flag.PrintDefaults()
+	fmt.Printf("       %s emulator [options...]\n", os.Args[0])
+	flags.PrintDefaults()
 	os.Exit(0)
 }
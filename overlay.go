@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/pkg/errors"
+)
+
+// sourceXattr is the extended attribute telling which vault served a file.
+const sourceXattr = "user.azkv.source"
+
+// objectSourcer is implemented by backends combining several vaults, to
+// tell which of them an object comes from.
+type objectSourcer interface {
+	Source(kind objectKind, name string) (string, bool)
+}
+
+// layeredBackend is implemented by backends combining several vaults, to
+// get at the vault serving an object. Objects belonging to it, like the
+// secret backing a certificate, must be read from that same vault.
+type layeredBackend interface {
+	layerOf(ctx context.Context, kind objectKind, name string) (Backend, error)
+}
+
+// sourceBackend returns the backend of the vault serving an object, which
+// is backend itself unless it combines several vaults.
+func sourceBackend(ctx context.Context, backend Backend, kind objectKind, name string) (Backend, error) {
+	layered, ok := backend.(layeredBackend)
+	if !ok {
+		return backend, nil
+	}
+	return layered.layerOf(ctx, kind, name)
+}
+
+// overlayLayer is a vault of an overlay.
+type overlayLayer struct {
+	name    string
+	backend Backend
+}
+
+// overlayBackend merges several vaults into one. For every name the first
+// layer containing an object of that name wins, so environment-specific
+// vaults can be layered on top of a shared baseline. New objects are
+// written to the first layer.
+type overlayBackend struct {
+	layers []overlayLayer
+
+	mutex sync.Mutex
+	// sources maps the objects of each kind to the index of the layer
+	// serving them.
+	sources map[objectKind]map[string]int
+}
+
+var (
+	_ Backend        = (*overlayBackend)(nil)
+	_ objectSourcer  = (*overlayBackend)(nil)
+	_ layeredBackend = (*overlayBackend)(nil)
+)
+
+func newOverlayBackend(layers []overlayLayer) *overlayBackend {
+	return &overlayBackend{
+		layers:  layers,
+		sources: map[objectKind]map[string]int{},
+	}
+}
+
+func (overlay *overlayBackend) setSource(kind objectKind, name string, layer int) {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+	if overlay.sources[kind] == nil {
+		overlay.sources[kind] = map[string]int{}
+	}
+	overlay.sources[kind][name] = layer
+}
+
+// forgetSource drops the layer serving an object, unless another request
+// has found a different one meanwhile.
+func (overlay *overlayBackend) forgetSource(kind objectKind, name string, layer int) {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+	if current, ok := overlay.sources[kind][name]; ok && current == layer {
+		delete(overlay.sources[kind], name)
+	}
+}
+
+func (overlay *overlayBackend) sourceLayer(kind objectKind, name string) (int, bool) {
+	overlay.mutex.Lock()
+	defer overlay.mutex.Unlock()
+	layer, ok := overlay.sources[kind][name]
+	return layer, ok
+}
+
+func (overlay *overlayBackend) Source(kind objectKind, name string) (string, bool) {
+	layer, ok := overlay.sourceLayer(kind, name)
+	if !ok {
+		return "", false
+	}
+	return overlay.layers[layer].name, true
+}
+
+// layerOf returns the backend of the layer serving the object, looking it
+// up if it was not listed.
+func (overlay *overlayBackend) layerOf(ctx context.Context, kind objectKind, name string) (Backend, error) {
+	layer, ok := overlay.sourceLayer(kind, name)
+	if !ok {
+		_, err := overlayGet(overlay, kind, name, func(backend Backend) (any, error) {
+			return fetchObject(ctx, backend, kind, name)
+		})
+		if err != nil {
+			return nil, err
+		}
+		layer, _ = overlay.sourceLayer(kind, name)
+	}
+	return overlay.layers[layer].backend, nil
+}
+
+// List merges the listings of all layers. A failing layer fails the whole
+// listing, as it could hide objects of the layers below.
+func (overlay *overlayBackend) List(ctx context.Context, kind objectKind) ([]objectProperties, error) {
+	var objects []objectProperties
+	sources := map[string]int{}
+	for i, layer := range overlay.layers {
+		layerObjects, err := layer.backend.List(ctx, kind)
+		if err != nil {
+			if errnoFor(err) == syscall.EOPNOTSUPP {
+				// A Managed HSM only has keys
+				continue
+			}
+			return nil, errors.Wrapf(err, "could not list vault %s", layer.name)
+		}
+		for _, object := range layerObjects {
+			if _, found := sources[object.name]; found {
+				continue
+			}
+			sources[object.name] = i
+			objects = append(objects, object)
+		}
+	}
+	overlay.mutex.Lock()
+	overlay.sources[kind] = sources
+	overlay.mutex.Unlock()
+	return objects, nil
+}
+
+// overlayGet gets an object from the layer that served it last, or else
+// from the first layer that has it, skipping layers that cannot store
+// objects of its kind. An object gone from the layer that served it is
+// looked up again, as a layer below may have one of the same name.
+func overlayGet[T any](overlay *overlayBackend, kind objectKind, name string,
+	get func(backend Backend) (T, error)) (T, error) {
+	if layer, ok := overlay.sourceLayer(kind, name); ok {
+		result, err := get(overlay.layers[layer].backend)
+		if err == nil || errnoFor(err) != syscall.ENOENT {
+			return result, err
+		}
+		overlay.forgetSource(kind, name, layer)
+	}
+	var result T
+	var err error
+	for i, layer := range overlay.layers {
+		result, err = get(layer.backend)
+		if err == nil {
+			overlay.setSource(kind, name, i)
+			return result, nil
+		}
+		if errno := errnoFor(err); errno != syscall.ENOENT && errno != syscall.EOPNOTSUPP {
+			return result, err
+		}
+	}
+	return result, err
+}
+
+func (overlay *overlayBackend) GetCertificate(ctx context.Context, name string) (azcertificates.Certificate, error) {
+	return overlayGet(overlay, certificateKind, name, func(backend Backend) (azcertificates.Certificate, error) {
+		return backend.GetCertificate(ctx, name)
+	})
+}
+
+func (overlay *overlayBackend) GetKey(ctx context.Context, name string) (azkeys.KeyBundle, error) {
+	return overlayGet(overlay, keyKind, name, func(backend Backend) (azkeys.KeyBundle, error) {
+		return backend.GetKey(ctx, name)
+	})
+}
+
+func (overlay *overlayBackend) GetSecret(ctx context.Context, name string) (azsecrets.Secret, error) {
+	return overlayGet(overlay, secretKind, name, func(backend Backend) (azsecrets.Secret, error) {
+		return backend.GetSecret(ctx, name)
+	})
+}
+
+func (overlay *overlayBackend) ImportCertificate(ctx context.Context, name string,
+	parameters azcertificates.ImportCertificateParameters) (azcertificates.Certificate, error) {
+	return overlay.layers[0].backend.ImportCertificate(ctx, name, parameters)
+}
+
+func (overlay *overlayBackend) ImportKey(ctx context.Context, name string,
+	parameters azkeys.ImportKeyParameters) (azkeys.KeyBundle, error) {
+	return overlay.layers[0].backend.ImportKey(ctx, name, parameters)
+}
+
+func (overlay *overlayBackend) SetSecret(ctx context.Context, name string,
+	parameters azsecrets.SetSecretParameters) (azsecrets.Secret, error) {
+	return overlay.layers[0].backend.SetSecret(ctx, name, parameters)
+}
+
+// Delete removes the object from the layer serving it, which may reveal
+// an object of the same name from a layer below.
+func (overlay *overlayBackend) Delete(ctx context.Context, kind objectKind, name string) error {
+	layer, ok := overlay.sourceLayer(kind, name)
+	if !ok {
+		layer = 0
+	}
+	if err := overlay.layers[layer].backend.Delete(ctx, kind, name); err != nil {
+		return err
+	}
+	overlay.mutex.Lock()
+	delete(overlay.sources[kind], name)
+	overlay.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setTestSecret(t *testing.T, backend Backend, name string, value string) {
+	_, err := backend.SetSecret(context.Background(), name, azsecrets.SetSecretParameters{Value: &value})
+	require.NoError(t, err)
+}
+
+func Test_overlayBackend(t *testing.T) {
+	ctx := context.Background()
+	prod, baseline := newTestVault(t), newTestVault(t)
+	setTestSecret(t, prod, "database", "prod database")
+	setTestSecret(t, baseline, "database", "baseline database")
+	setTestSecret(t, baseline, "smtp", "baseline smtp")
+	overlay := newOverlayBackend([]overlayLayer{{"prod", prod}, {"baseline", baseline}})
+
+	secrets, err := overlay.List(ctx, secretKind)
+	require.NoError(t, err)
+	require.Len(t, secrets, 2)
+	assert.Equal(t, "database", secrets[0].name)
+	assert.Equal(t, "smtp", secrets[1].name)
+
+	secret, err := overlay.GetSecret(ctx, "database")
+	require.NoError(t, err)
+	assert.Equal(t, "prod database", *secret.Value, "the first vault wins")
+	source, ok := overlay.Source(secretKind, "database")
+	assert.True(t, ok)
+	assert.Equal(t, "prod", source)
+	source, _ = overlay.Source(secretKind, "smtp")
+	assert.Equal(t, "baseline", source)
+
+	require.NoError(t, overlay.Delete(ctx, secretKind, "database"))
+	secret, err = overlay.GetSecret(ctx, "database")
+	require.NoError(t, err)
+	assert.Equal(t, "baseline database", *secret.Value, "deleting reveals the layer below")
+
+	setTestSecret(t, prod, "database", "prod database")
+	_, err = overlay.List(ctx, secretKind)
+	require.NoError(t, err)
+	require.NoError(t, prod.Delete(ctx, secretKind, "database"))
+	secret, err = overlay.GetSecret(ctx, "database")
+	require.NoError(t, err)
+	assert.Equal(t, "baseline database", *secret.Value, "objects gone from their layer are looked up again")
+	source, _ = overlay.Source(secretKind, "database")
+	assert.Equal(t, "baseline", source)
+
+	_, err = newOverlayBackend([]overlayLayer{{"prod", prod}, {"baseline", baseline}}).GetSecret(ctx, "missing")
+	assert.Error(t, err)
+	_, ok = overlay.Source(keyKind, "missing")
+	assert.False(t, ok)
+}
+
+func Test_overlayBackend_certificateSecret(t *testing.T) {
+	ctx := context.Background()
+	_, intermediate, leaf := testHierarchy(t)
+	keyPEM, err := marshalPrivateKeyPEM(leaf.key)
+	require.NoError(t, err)
+	value := append(append(keyPEM, encodeCertificate(leaf.certificate.Raw)...),
+		encodeCertificate(intermediate.certificate.Raw)...)
+	encoded := base64.StdEncoding.EncodeToString(value)
+
+	// The plain secret in the upper vault has the name of the certificate
+	// in the lower one
+	prod, baseline := newTestVault(t), newTestVault(t)
+	setTestSecret(t, prod, "web", "not a certificate")
+	_, err = baseline.ImportCertificate(ctx, "web", azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &encoded,
+	})
+	require.NoError(t, err)
+
+	for _, listed := range []bool{false, true} {
+		overlay := newOverlayBackend([]overlayLayer{{"prod", prod}, {"baseline", baseline}})
+		if listed {
+			_, err := overlay.List(ctx, certificateKind)
+			require.NoError(t, err)
+		}
+		for suffix, expected := range map[string][]string{
+			".key.pem":       {"PRIVATE KEY"},
+			".fullchain.pem": {"example.com", "Test Intermediate"},
+			".bundle.pem":    {"PRIVATE KEY", "example.com", "Test Intermediate"},
+		} {
+			v := viewFor(certificateKind, suffix)
+			object, err := v.fetch(ctx, overlay, "web")
+			require.NoError(t, err, suffix)
+			data, err := v.render(ctx, object)
+			require.NoError(t, err, suffix)
+			assert.Equal(t, expected, pemCertificates(data), "the secret comes from the certificate's vault")
+		}
+		secret, err := overlay.GetSecret(ctx, "web")
+		require.NoError(t, err)
+		assert.Equal(t, "not a certificate", *secret.Value, "secrets/web is still the upper one")
+	}
+}
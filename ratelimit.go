@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"golang.org/x/time/rate"
+)
+
+// throttleMetrics counts how often calls to Key Vault were held back, either
+// by our own rate limiter or because Key Vault told us to slow down.
+type throttleMetrics struct {
+	requests       atomic.Uint64
+	limitedLocally atomic.Uint64
+	throttled      atomic.Uint64
+	waitedNanos    atomic.Int64
+}
+
+func (m *throttleMetrics) String() string {
+	return fmt.Sprintf("requests=%d limited=%d throttled=%d waited=%s",
+		m.requests.Load(),
+		m.limitedLocally.Load(),
+		m.throttled.Load(),
+		time.Duration(m.waitedNanos.Load()))
+}
+
+// throttle is a pipeline policy shared by the secrets, keys and certificates
+// clients. It applies a client-side token bucket to every attempt and, once
+// Key Vault answers with 429 or 503, holds back all clients until the
+// Retry-After period has passed.
+type throttle struct {
+	limiter      *rate.Limiter
+	blockedUntil atomic.Int64
+	metrics      throttleMetrics
+}
+
+var (
+	_ policy.Policy = (*throttle)(nil)
+)
+
+// newThrottle creates a throttle allowing requestsPerSecond with the given
+// burst. A requestsPerSecond of zero or less disables the token bucket.
+func newThrottle(requestsPerSecond float64, burst int) *throttle {
+	limit := rate.Limit(requestsPerSecond)
+	if requestsPerSecond <= 0 {
+		limit = rate.Inf
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &throttle{
+		limiter: rate.NewLimiter(limit, burst),
+	}
+}
+
+func (t *throttle) wait(req *policy.Request) error {
+	ctx := req.Raw().Context()
+	start := time.Now()
+
+	if until := time.Unix(0, t.blockedUntil.Load()); start.Before(until) {
+		timer := time.NewTimer(until.Sub(start))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	if err := t.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	if waited := time.Since(start); waited > time.Millisecond {
+		t.metrics.limitedLocally.Add(1)
+		t.metrics.waitedNanos.Add(int64(waited))
+	}
+	return nil
+}
+
+func (t *throttle) Do(req *policy.Request) (*http.Response, error) {
+	if err := t.wait(req); err != nil {
+		return nil, err
+	}
+	t.metrics.requests.Add(1)
+
+	resp, err := req.Next()
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		t.metrics.throttled.Add(1)
+		delay := retryAfter(resp)
+		if delay > 0 {
+			until := time.Now().Add(delay).UnixNano()
+			for {
+				current := t.blockedUntil.Load()
+				if current >= until || t.blockedUntil.CompareAndSwap(current, until) {
+					break
+				}
+			}
+		}
+		log.Println("Key Vault throttled", req.Raw().Method, req.Raw().URL.Path,
+			"status", resp.StatusCode, "retry after", delay, "-", t.metrics.String())
+	}
+	return resp, nil
+}
+
+// retryAfter returns the delay requested by the server, or zero if there is
+// none. Key Vault may send it in milliseconds via retry-after-ms or
+// x-ms-retry-after-ms, or in seconds or as an HTTP date via Retry-After.
+func retryAfter(resp *http.Response) time.Duration {
+	for _, header := range []string{"retry-after-ms", "x-ms-retry-after-ms"} {
+		if value := resp.Header.Get(header); value != "" {
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				return time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
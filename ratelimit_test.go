@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_retryAfter(t *testing.T) {
+	for name, test := range map[string]struct {
+		header   string
+		value    string
+		expected time.Duration
+	}{
+		"none":                {"", "", 0},
+		"retry-after-ms":      {"retry-after-ms", "1500", 1500 * time.Millisecond},
+		"x-ms-retry-after-ms": {"x-ms-retry-after-ms", "250", 250 * time.Millisecond},
+		"seconds":             {"Retry-After", "3", 3 * time.Second},
+		"zero seconds":        {"Retry-After", "0", 0},
+		"negative":            {"x-ms-retry-after-ms", "-5", 0},
+		"garbage":             {"Retry-After", "soon", 0},
+		"past date":           {"Retry-After", "Mon, 02 Jan 2006 15:04:05 GMT", 0},
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		if len(test.header) != 0 {
+			resp.Header.Set(test.header, test.value)
+		}
+		assert.Equal(t, test.expected, retryAfter(resp), name)
+	}
+
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	delay := retryAfter(resp)
+	assert.True(t, delay > 58*time.Second && delay <= time.Minute, "HTTP date, got %s", delay)
+
+	resp.Header.Set("x-ms-retry-after-ms", "100")
+	assert.Equal(t, 100*time.Millisecond, retryAfter(resp), "milliseconds take precedence")
+}
+
+// newThrottledPipeline sends requests through throttle only, without the
+// SDK retrying them.
+func newThrottledPipeline(throttle *throttle, server *httptest.Server) runtime.Pipeline {
+	return runtime.NewPipeline("azkv", "test", runtime.PipelineOptions{PerRetry: []policy.Policy{throttle}},
+		&policy.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}, Transport: server.Client()})
+}
+
+func Test_throttle(t *testing.T) {
+	var throttled atomic.Bool
+	throttled.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttled.CompareAndSwap(true, false) {
+			w.Header().Set("x-ms-retry-after-ms", "300")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	throttle := newThrottle(0, 1)
+	pipeline := newThrottledPipeline(throttle, server)
+	send := func(ctx context.Context) (*http.Response, error) {
+		req, err := runtime.NewRequest(ctx, http.MethodGet, server.URL)
+		require.NoError(t, err)
+		return pipeline.Do(req)
+	}
+
+	resp, err := send(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, uint64(1), throttle.metrics.throttled.Load())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = send(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "requests wait for Retry-After")
+
+	start := time.Now()
+	resp, err = send(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond, "held back until Retry-After passed")
+	assert.Equal(t, uint64(2), throttle.metrics.requests.Load(), "the request that gave up was not sent")
+	assert.Contains(t, throttle.metrics.String(), "throttled=1")
+}
+
+func Test_throttle_rateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	throttle := newThrottle(20, 1)
+	pipeline := newThrottledPipeline(throttle, server)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		req, err := runtime.NewRequest(context.Background(), http.MethodGet, server.URL)
+		require.NoError(t, err)
+		_, err = pipeline.Do(req)
+		require.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond, "20 requests per second with a burst of 1")
+	assert.Equal(t, uint64(2), throttle.metrics.limitedLocally.Load())
+}
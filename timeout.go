@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// operationTimeouts limits how long a single FUSE operation may wait for
+// Key Vault. The deadlines are applied on top of the request context, so an
+// interrupted syscall still aborts the underlying HTTP requests right away.
+type operationTimeouts struct {
+	// Lookup applies to looking up names and determining file attributes.
+	Lookup time.Duration `yaml:"lookup"`
+	// List applies to retrieving directory listings.
+	List time.Duration `yaml:"list"`
+	// Read applies to reading file contents.
+	Read time.Duration `yaml:"read"`
+}
+
+var timeouts = operationTimeouts{
+	Lookup: 10 * time.Second,
+	List:   30 * time.Second,
+	Read:   30 * time.Second,
+}
+
+func withOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (t operationTimeouts) lookupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withOptionalTimeout(ctx, t.Lookup)
+}
+
+func (t operationTimeouts) listContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withOptionalTimeout(ctx, t.List)
+}
+
+func (t operationTimeouts) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withOptionalTimeout(ctx, t.Read)
+}
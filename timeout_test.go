@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stuckBackend never answers requests for secrets until they are aborted.
+type stuckBackend struct {
+	Backend
+}
+
+func (backend stuckBackend) GetSecret(ctx context.Context, name string) (azsecrets.Secret, error) {
+	<-ctx.Done()
+	return azsecrets.Secret{}, ctx.Err()
+}
+
+func Test_withOptionalTimeout(t *testing.T) {
+	ctx, cancel := withOptionalTimeout(context.Background(), 0)
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline, "a zero timeout leaves the context unbounded")
+	cancel()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	parent, interrupt := context.WithCancel(context.Background())
+	ctx, cancel = withOptionalTimeout(parent, time.Hour)
+	defer cancel()
+	deadline, hasDeadline := ctx.Deadline()
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Minute)
+	interrupt()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled, "interrupting the request aborts the operation")
+}
+
+func Test_operationTimeouts(t *testing.T) {
+	ctx := context.Background()
+	vault := newTestVault(t)
+	setTestSecret(t, vault, "password", "hunter2")
+	secrets, err := newTestRoot(stuckBackend{vault}).Find(secretsDirName, ctx)
+	require.NoError(t, err)
+	entry, err := secrets.Find("password", ctx)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+
+	original := timeouts
+	t.Cleanup(func() { timeouts = original })
+	timeouts.Lookup = 20 * time.Millisecond
+	err = File{entry}.Attr(ctx, &fuse.Attr{})
+	assert.Equal(t, syscall.ETIMEDOUT, errnoFor(err), "an expired lookup")
+
+	interrupted, interrupt := context.WithCancel(ctx)
+	interrupt()
+	timeouts.Lookup = 0
+	err = File{entry}.Attr(interrupted, &fuse.Attr{})
+	assert.Equal(t, syscall.EINTR, errnoFor(err), "an interrupted lookup without a timeout")
+}
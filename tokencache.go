@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/pkg/errors"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+)
+
+// tokenCachePassphraseEnv names the variable with the passphrase that
+// encrypts the token cache. Without it, a random key is kept in the OS
+// keyring, or if there is none in a separate file readable only by the user.
+const tokenCachePassphraseEnv = "AZKV_TOKEN_CACHE_PASSPHRASE"
+
+// tokenCacheKeyringService is the keyring service the keys of token caches
+// are stored under, by cache file.
+const tokenCacheKeyringService = "azkv"
+
+// osKeyring is the keyring of the operating system.
+type osKeyring struct{}
+
+func (osKeyring) Set(service, user, password string) error {
+	return keyring.Set(service, user, password)
+}
+
+func (osKeyring) Get(service, user string) (string, error) {
+	return keyring.Get(service, user)
+}
+
+func (osKeyring) Delete(service, user string) error {
+	return keyring.Delete(service, user)
+}
+
+// tokenCacheKeyring keeps the keys of token caches. Tests replace it with
+// one of their own, as the mocks of go-keyring cannot be undone.
+var tokenCacheKeyring keyring.Keyring = osKeyring{}
+
+// tokenCacheMutex serializes access to token cache files, which several
+// vaults of one process may share.
+var tokenCacheMutex sync.Mutex
+
+const (
+	tokenCacheSaltSize = 16
+	tokenCacheKeySize  = 32
+)
+
+// tokenCache stores the MSAL token cache encrypted with AES-GCM, so that
+// interactive logins survive restarts. The file is the salt, the nonce and
+// the sealed cache.
+type tokenCache struct {
+	file    string
+	keyFile string
+	// keyFileWarning is logged once when the key is kept in keyFile.
+	keyFileWarning sync.Once
+}
+
+var _ cache.ExportReplace = (*tokenCache)(nil)
+
+// defaultTokenCache returns the token cache in the user's cache directory,
+// with its key file, if the key is not in the keyring, in the user's config
+// directory.
+func defaultTokenCache() (*tokenCache, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not determine the token cache directory")
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not determine the token cache key directory")
+	}
+	return &tokenCache{
+		file:    filepath.Join(cacheDir, "azkv", "token-cache"),
+		keyFile: filepath.Join(configDir, "azkv", "token-cache.key"),
+	}, nil
+}
+
+// key returns the encryption key for a cache file with the given salt.
+func (tokenCache *tokenCache) key(salt []byte) ([]byte, error) {
+	if passphrase, found := os.LookupEnv(tokenCachePassphraseEnv); found {
+		return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, tokenCacheKeySize)
+	}
+	key, err := tokenCache.keyringKey()
+	if err == nil {
+		return key, nil
+	}
+	tokenCache.keyFileWarning.Do(func() {
+		log.Printf("No OS keyring (%v): keeping the token cache key in %s, which protects the cache no better "+
+			"than its file permissions; set %s to encrypt it with a passphrase", err, tokenCache.keyFile, tokenCachePassphraseEnv)
+	})
+	return tokenCache.fileKey()
+}
+
+// keyringKey returns the key from the OS keyring, creating it or moving it
+// there from the key file if needed.
+func (tokenCache *tokenCache) keyringKey() ([]byte, error) {
+	encoded, err := tokenCacheKeyring.Get(tokenCacheKeyringService, tokenCache.file)
+	if err == nil {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != tokenCacheKeySize {
+			return nil, errors.Errorf("token cache key in the keyring is corrupt")
+		}
+		return key, nil
+	}
+	if !errors.Is(err, keyring.ErrNotFound) {
+		return nil, err
+	}
+	key, err := tokenCache.readKeyFile()
+	if err != nil {
+		return nil, err
+	}
+	moved := key != nil
+	if !moved {
+		if key, err = newTokenCacheKey(); err != nil {
+			return nil, err
+		}
+	}
+	if err := tokenCacheKeyring.Set(tokenCacheKeyringService, tokenCache.file, base64.StdEncoding.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+	if moved {
+		if err := os.Remove(tokenCache.keyFile); err != nil {
+			log.Println("Could not remove the token cache key file after moving it to the keyring:", err)
+		}
+	}
+	return key, nil
+}
+
+// fileKey returns the key from the key file, creating it if needed.
+func (tokenCache *tokenCache) fileKey() ([]byte, error) {
+	key, err := tokenCache.readKeyFile()
+	if err != nil || key != nil {
+		return key, err
+	}
+	if key, err = newTokenCacheKey(); err != nil {
+		return nil, err
+	}
+	if err := writePrivateFile(tokenCache.keyFile, key); err != nil {
+		return nil, errors.Wrap(err, "could not store the token cache key")
+	}
+	return key, nil
+}
+
+// readKeyFile returns the key in the key file, or nil if there is none.
+func (tokenCache *tokenCache) readKeyFile() ([]byte, error) {
+	key, err := os.ReadFile(tokenCache.keyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read the token cache key")
+	}
+	if len(key) != tokenCacheKeySize {
+		return nil, errors.Errorf("token cache key %s is corrupt", tokenCache.keyFile)
+	}
+	return key, nil
+}
+
+func newTokenCacheKey() ([]byte, error) {
+	key := make([]byte, tokenCacheKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.Wrap(err, "could not generate the token cache key")
+	}
+	return key, nil
+}
+
+func (tokenCache *tokenCache) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := tokenCache.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Replace loads the cache from the file. A missing file is an empty cache.
+func (tokenCache *tokenCache) Replace(ctx context.Context, unmarshaler cache.Unmarshaler, hints cache.ReplaceHints) error {
+	tokenCacheMutex.Lock()
+	defer tokenCacheMutex.Unlock()
+	data, err := os.ReadFile(tokenCache.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not read the token cache")
+	}
+	if len(data) < tokenCacheSaltSize {
+		return errors.Errorf("token cache %s is corrupt", tokenCache.file)
+	}
+	salt, sealed := data[:tokenCacheSaltSize], data[tokenCacheSaltSize:]
+	aead, err := tokenCache.aead(salt)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return errors.Errorf("token cache %s is corrupt", tokenCache.file)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return errors.Errorf("could not decrypt the token cache %s; was the key or passphrase changed?", tokenCache.file)
+	}
+	return unmarshaler.Unmarshal(plain)
+}
+
+// Export writes the cache to the file, encrypted with a new salt and nonce.
+func (tokenCache *tokenCache) Export(ctx context.Context, marshaler cache.Marshaler, hints cache.ExportHints) error {
+	plain, err := marshaler.Marshal()
+	if err != nil {
+		return err
+	}
+	tokenCacheMutex.Lock()
+	defer tokenCacheMutex.Unlock()
+	salt := make([]byte, tokenCacheSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	aead, err := tokenCache.aead(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := append(append(salt, nonce...), aead.Seal(nil, nonce, plain, nil)...)
+	if err := writePrivateFile(tokenCache.file, data); err != nil {
+		return errors.Wrap(err, "could not write the token cache")
+	}
+	return nil
+}
+
+// writePrivateFile atomically replaces file with data only the user can
+// read, creating its directory if needed.
+func writePrivateFile(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+)
+
+// testCacheData is the serialized MSAL cache handed to a tokenCache.
+type testCacheData struct {
+	data []byte
+}
+
+func (cache *testCacheData) Marshal() ([]byte, error) {
+	return cache.data, nil
+}
+
+func (cache *testCacheData) Unmarshal(data []byte) error {
+	cache.data = data
+	return nil
+}
+
+// testKeyring keeps secrets in memory, or fails with err if it is set.
+type testKeyring struct {
+	err     error
+	secrets map[string]string
+}
+
+var _ keyring.Keyring = (*testKeyring)(nil)
+
+// useTestKeyring keeps token cache keys in a new testKeyring for the rest of
+// the test.
+func useTestKeyring(t *testing.T, err error) *testKeyring {
+	original := tokenCacheKeyring
+	t.Cleanup(func() { tokenCacheKeyring = original })
+	k := &testKeyring{err: err, secrets: map[string]string{}}
+	tokenCacheKeyring = k
+	return k
+}
+
+func (k *testKeyring) Set(service, user, password string) error {
+	if k.err != nil {
+		return k.err
+	}
+	k.secrets[service+"/"+user] = password
+	return nil
+}
+
+func (k *testKeyring) Get(service, user string) (string, error) {
+	if k.err != nil {
+		return "", k.err
+	}
+	password, ok := k.secrets[service+"/"+user]
+	if !ok {
+		return "", keyring.ErrNotFound
+	}
+	return password, nil
+}
+
+func (k *testKeyring) Delete(service, user string) error {
+	if k.err != nil {
+		return k.err
+	}
+	delete(k.secrets, service+"/"+user)
+	return nil
+}
+
+func Test_tokenCache(t *testing.T) {
+	ctx := context.Background()
+	useTestKeyring(t, errors.New("no keyring"))
+	dir := t.TempDir()
+	tokens := &tokenCache{file: filepath.Join(dir, "cache", "tokens"), keyFile: filepath.Join(dir, "config", "tokens.key")}
+
+	loaded := &testCacheData{}
+	require.NoError(t, tokens.Replace(ctx, loaded, cache.ReplaceHints{}), "a missing cache is empty")
+	assert.Nil(t, loaded.data)
+
+	secret := []byte(`{"RefreshToken":{"secret":"refresh-token"}}`)
+	require.NoError(t, tokens.Export(ctx, &testCacheData{data: secret}, cache.ExportHints{}))
+	for _, file := range []string{tokens.file, tokens.keyFile} {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), file)
+	}
+	stored, err := os.ReadFile(tokens.file)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(stored, []byte("refresh-token")), "the cache is encrypted")
+
+	require.NoError(t, tokens.Replace(ctx, loaded, cache.ReplaceHints{}))
+	assert.Equal(t, secret, loaded.data)
+
+	t.Setenv(tokenCachePassphraseEnv, "correct horse")
+	assert.ErrorContains(t, tokens.Replace(ctx, loaded, cache.ReplaceHints{}), "could not decrypt")
+	require.NoError(t, tokens.Export(ctx, &testCacheData{data: secret}, cache.ExportHints{}))
+	require.NoError(t, tokens.Replace(ctx, loaded, cache.ReplaceHints{}))
+	assert.Equal(t, secret, loaded.data)
+}
+
+func Test_tokenCache_keyring(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	tokens := &tokenCache{file: filepath.Join(dir, "cache", "tokens"), keyFile: filepath.Join(dir, "config", "tokens.key")}
+	secret := []byte(`{"RefreshToken":{"secret":"refresh-token"}}`)
+
+	// A cache from a host without a keyring
+	useTestKeyring(t, errors.New("no keyring"))
+	require.NoError(t, tokens.Export(ctx, &testCacheData{data: secret}, cache.ExportHints{}))
+	require.FileExists(t, tokens.keyFile)
+
+	working := useTestKeyring(t, nil)
+	loaded := &testCacheData{}
+	require.NoError(t, tokens.Replace(ctx, loaded, cache.ReplaceHints{}))
+	assert.Equal(t, secret, loaded.data, "the key file is moved to the keyring")
+	assert.NoFileExists(t, tokens.keyFile)
+	_, err := working.Get(tokenCacheKeyringService, tokens.file)
+	assert.NoError(t, err)
+
+	require.NoError(t, tokens.Export(ctx, &testCacheData{data: secret}, cache.ExportHints{}))
+	require.NoError(t, tokens.Replace(ctx, loaded, cache.ReplaceHints{}))
+	assert.Equal(t, secret, loaded.data)
+	assert.NoFileExists(t, tokens.keyFile)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// vaultConfig is one of several vaults mounted by the same process. Vaults
+// with their own mount point are mounted there; the others are shown as
+// directories named after them under the top-level mount point.
+type vaultConfig struct {
+	Name       string `yaml:"name"`
+	URL        string `yaml:"url"`
+	Local      string `yaml:"local"`
+	MountPoint string `yaml:"mount_point"`
+	HSM        bool   `yaml:"hsm"`
+	// Credentials override the top-level credentials for this vault.
+	Credentials *credentialConfig `yaml:"credentials"`
+}
+
+// isManagedHSM reports whether the vault is a Managed HSM.
+func (vault vaultConfig) isManagedHSM() bool {
+	return vault.HSM || isManagedHSMURL(vault.URL)
+}
+
+// source is the URL or directory the vault is read from.
+func (vault vaultConfig) source() string {
+	if len(vault.Local) != 0 {
+		return vault.Local
+	}
+	return vault.URL
+}
+
+// vaultList is the -vault flag, given as name=url or name=directory. Like
+// stringList, the first use replaces the vaults from the config file.
+type vaultList struct {
+	vaults *[]vaultConfig
+	set    bool
+}
+
+func (list *vaultList) String() string {
+	if list.vaults == nil {
+		return ""
+	}
+	var values []string
+	for _, vault := range *list.vaults {
+		values = append(values, vault.Name+"="+vault.source())
+	}
+	return strings.Join(values, ",")
+}
+
+func (list *vaultList) Set(value string) error {
+	name, source, found := strings.Cut(value, "=")
+	if !found || len(name) == 0 || len(source) == 0 {
+		return errors.Errorf("invalid vault %q, expected name=url or name=directory", value)
+	}
+	if !list.set {
+		*list.vaults = nil
+		list.set = true
+	}
+	vault := vaultConfig{Name: name}
+	if strings.Contains(source, "://") {
+		vault.URL = source
+	} else {
+		vault.Local = source
+	}
+	*list.vaults = append(*list.vaults, vault)
+	return nil
+}
+
+// mountPlan is a mount point and the vaults shown in it. If shared, the
+// vaults are subdirectories; otherwise there is exactly one vault at the
+// root.
+type mountPlan struct {
+	dir    string
+	vaults []vaultConfig
+	shared bool
+}
+
+// mountPlans decides what to mount where.
+func (config *Config) mountPlans() []mountPlan {
+	if len(config.Vaults) == 0 {
+		return []mountPlan{{
+			dir:    config.MountPoint,
+			vaults: []vaultConfig{{URL: config.URL, Local: config.Local, HSM: config.HSM}},
+		}}
+	}
+	var plans []mountPlan
+	shared := mountPlan{dir: config.MountPoint, shared: true}
+	for _, vault := range config.Vaults {
+		if len(vault.MountPoint) != 0 {
+			plans = append(plans, mountPlan{dir: vault.MountPoint, vaults: []vaultConfig{vault}})
+		} else {
+			shared.vaults = append(shared.vaults, vault)
+		}
+	}
+	if len(shared.vaults) != 0 {
+		plans = append(plans, shared)
+	}
+	return plans
+}
+
+// credentials returns the credentials to use for a vault.
+func (config *Config) credentials(vault vaultConfig) credentialConfig {
+	if vault.Credentials != nil {
+		return *vault.Credentials
+	}
+	return config.Credentials
+}
+
+// validateVaults reports problems with the vaults list.
+func (config *Config) validateVaults(report func(format string, args ...any)) {
+	if len(config.URL) != 0 || len(config.Local) != 0 {
+		report("url and local cannot be used together with vaults")
+	}
+	names := map[string]bool{}
+	mountPoints := map[string]bool{}
+	for i, vault := range config.Vaults {
+		where := fmt.Sprintf("vaults[%d]", i)
+		if len(vault.Name) == 0 || strings.Contains(vault.Name, "/") || vault.Name == "." || vault.Name == ".." {
+			report("%s.name %q is invalid", where, vault.Name)
+		} else if names[vault.Name] {
+			report("%s.name %q is used twice", where, vault.Name)
+		}
+		names[vault.Name] = true
+		if (len(vault.URL) == 0) == (len(vault.Local) == 0) {
+			report("%s needs exactly one of url and local", where)
+		}
+		if len(vault.URL) != 0 {
+			validateURL(report, where+".url", vault.URL)
+		} else if vault.HSM {
+			report("%s.hsm needs url", where)
+		}
+		if vault.Credentials != nil {
+			validateCredentials(report, where+".credentials", *vault.Credentials)
+		}
+		if len(vault.MountPoint) != 0 {
+			if mountPoints[vault.MountPoint] || vault.MountPoint == config.MountPoint {
+				report("%s.mount_point %q is used twice", where, vault.MountPoint)
+			}
+			mountPoints[vault.MountPoint] = true
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	registerView(&objectView[azcertificates.Certificate]{
+		objectKind: certificateKind,
+		nameSuffix: "",
+		renderer: func(ctx context.Context, certificate azcertificates.Certificate) ([]byte, error) {
+			return certificate.CER, nil
+		},
+	})
+	registerView(&objectView[azcertificates.Certificate]{
+		objectKind: certificateKind,
+		nameSuffix: ".pem",
+		renderer: func(ctx context.Context, certificate azcertificates.Certificate) ([]byte, error) {
+			return ConvertEntry(pemCertType, certificate.CER), nil
+		},
+	})
+	registerView(&objectView[azcertificates.Certificate]{
+		objectKind: certificateKind,
+		nameSuffix: ".chain.pem",
+		renderer: func(ctx context.Context, certificate azcertificates.Certificate) ([]byte, error) {
+			return certChain(ctx, certificate.CER)
+		},
+	})
+	registerView(&objectView[*certificateBundle]{
+		objectKind: certificateKind,
+		nameSuffix: ".key.pem",
+		fetcher:    fetchCertificateBundle,
+		renderer: func(ctx context.Context, bundle *certificateBundle) ([]byte, error) {
+			return bundle.keyPEM()
+		},
+	})
+	registerView(&objectView[*certificateBundle]{
+		objectKind: certificateKind,
+		nameSuffix: ".fullchain.pem",
+		fetcher:    fetchCertificateBundle,
+		renderer: func(ctx context.Context, bundle *certificateBundle) ([]byte, error) {
+			return bundle.fullChainPEM(), nil
+		},
+	})
+	registerView(&objectView[*certificateBundle]{
+		objectKind: certificateKind,
+		nameSuffix: ".bundle.pem",
+		fetcher:    fetchCertificateBundle,
+		renderer: func(ctx context.Context, bundle *certificateBundle) ([]byte, error) {
+			key, err := bundle.keyPEM()
+			if err != nil {
+				return nil, err
+			}
+			return append(key, bundle.fullChainPEM()...), nil
+		},
+	})
+	registerView(&objectView[*keystoreSource]{
+		objectKind: certificateKind,
+		nameSuffix: ".p12",
+		fetcher:    fetchKeystoreSource,
+		renderer: func(ctx context.Context, source *keystoreSource) ([]byte, error) {
+			return source.pkcs12()
+		},
+	})
+	registerView(&objectView[*keystoreSource]{
+		objectKind: certificateKind,
+		nameSuffix: ".jks",
+		fetcher:    fetchKeystoreSource,
+		renderer: func(ctx context.Context, source *keystoreSource) ([]byte, error) {
+			return source.jks()
+		},
+	})
+	registerView(&objectView[azcertificates.Certificate]{
+		objectKind: certificateKind,
+		nameSuffix: ".info",
+		renderer: func(ctx context.Context, certificate azcertificates.Certificate) ([]byte, error) {
+			info, err := parseCertificateInfo(certificate, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return info.text(), nil
+		},
+	})
+	registerView(&objectView[azcertificates.Certificate]{
+		objectKind: certificateKind,
+		nameSuffix: ".info.json",
+		renderer: func(ctx context.Context, certificate azcertificates.Certificate) ([]byte, error) {
+			info, err := parseCertificateInfo(certificate, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return info.json()
+		},
+	})
+	registerView(&objectView[azcertificates.Certificate]{
+		objectKind: certificateKind,
+		nameSuffix: ".sha256",
+		renderer: func(ctx context.Context, certificate azcertificates.Certificate) ([]byte, error) {
+			sum := sha256.Sum256(certificate.CER)
+			return []byte(fingerprint(sum[:]) + "\n"), nil
+		},
+	})
+	registerView(&objectView[azcertificates.Certificate]{
+		objectKind: certificateKind,
+		nameSuffix: ".sha1",
+		renderer: func(ctx context.Context, certificate azcertificates.Certificate) ([]byte, error) {
+			sum := sha1.Sum(certificate.CER)
+			return []byte(fingerprint(sum[:]) + "\n"), nil
+		},
+	})
+	registerView(&objectView[azcertificates.Certificate]{
+		objectKind: certificateKind,
+		nameSuffix: ".response",
+		renderer: func(ctx context.Context, certificate azcertificates.Certificate) ([]byte, error) {
+			return json.Marshal(redactKeystorePassword(certificate))
+		},
+	})
+}
+
+// fetchCertificateBundle retrieves the certificate's backing secret, which
+// has the same name and holds the private key and the full chain as PEM or
+// PKCS#12. In an overlay, the secret is read from the vault serving the
+// certificate, not from whichever has a secret of that name first.
+func fetchCertificateBundle(ctx context.Context, backend Backend, name string) (*certificateBundle, error) {
+	source, err := sourceBackend(ctx, backend, certificateKind, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get certificate")
+	}
+	secret, err := source.GetSecret(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get the certificate's secret")
+	}
+	bundle, err := parseCertificateBundle(secretValue(secret), "")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse the certificate's secret")
+	}
+	return bundle, nil
+}
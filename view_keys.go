@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	registerView(&objectView[azkeys.KeyBundle]{
+		objectKind: keyKind,
+		nameSuffix: "",
+		renderer: func(ctx context.Context, key azkeys.KeyBundle) ([]byte, error) {
+			return keyMaterial(key)
+		},
+	})
+	// .pem predates .pub.pem and is kept for compatibility. Key Vault never
+	// returns private keys, so it holds the public key as well.
+	registerView(&objectView[azkeys.KeyBundle]{
+		objectKind: keyKind,
+		nameSuffix: ".pem",
+		renderer: func(ctx context.Context, key azkeys.KeyBundle) ([]byte, error) {
+			return publicKeyPEM(key)
+		},
+	})
+	registerView(&objectView[azkeys.KeyBundle]{
+		objectKind: keyKind,
+		nameSuffix: ".pub.pem",
+		renderer: func(ctx context.Context, key azkeys.KeyBundle) ([]byte, error) {
+			return publicKeyPEM(key)
+		},
+	})
+	registerView(&objectView[azkeys.KeyBundle]{
+		objectKind: keyKind,
+		nameSuffix: ".pub.ssh",
+		renderer: func(ctx context.Context, key azkeys.KeyBundle) ([]byte, error) {
+			return publicKeySSH(key)
+		},
+	})
+	registerView(&objectView[azkeys.KeyBundle]{
+		objectKind: keyKind,
+		nameSuffix: ".jwk.json",
+		renderer: func(ctx context.Context, key azkeys.KeyBundle) ([]byte, error) {
+			return publicKeyJWK(key)
+		},
+	})
+	registerView(&objectView[azkeys.KeyBundle]{
+		objectKind: keyKind,
+		nameSuffix: ".response",
+		renderer: func(ctx context.Context, key azkeys.KeyBundle) ([]byte, error) {
+			return json.Marshal(key)
+		},
+	})
+}
+
+// errNoKeyMaterial is returned for the bare file of RSA and EC keys, whose
+// private part Key Vault never returns.
+var errNoKeyMaterial = errors.WithMessage(syscall.ENODATA,
+	"only symmetric keys have key material; the public key of RSA and EC keys is in .pub.pem")
+
+// keyMaterial returns the key of a symmetric key.
+func keyMaterial(key azkeys.KeyBundle) ([]byte, error) {
+	if key.Key == nil || key.Key.Kty == nil {
+		return nil, errNoKeyMaterial
+	}
+	switch *key.Key.Kty {
+	case azkeys.KeyTypeOct, azkeys.KeyTypeOctHSM:
+		return key.Key.K, nil
+	default:
+		return nil, errNoKeyMaterial
+	}
+}
+
+// publicKeyPEM encodes the public key as a PEM SubjectPublicKeyInfo.
+func publicKeyPEM(key azkeys.KeyBundle) ([]byte, error) {
+	public, err := publicKeyFromJSONWebKey(key.Key)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode public key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// publicKeySSH encodes the public key as an authorized_keys line, commented
+// with the key's name.
+func publicKeySSH(key azkeys.KeyBundle) ([]byte, error) {
+	public, err := publicKeyFromJSONWebKey(key.Key)
+	if err != nil {
+		return nil, err
+	}
+	sshKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode public key for SSH")
+	}
+	line := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(sshKey), []byte("\n"))
+	if key.Key.KID != nil {
+		line = append(append(line, ' '), key.Key.KID.Name()...)
+	}
+	return append(line, '\n'), nil
+}
+
+// publicKeyJWK encodes the public key as a JSON web key.
+func publicKeyJWK(key azkeys.KeyBundle) ([]byte, error) {
+	if _, err := publicKeyFromJSONWebKey(key.Key); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(publicJSONWebKey(key.Key))
+	if err != nil {
+		return nil, err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return nil, err
+	}
+	return append(indented.Bytes(), '\n'), nil
+}
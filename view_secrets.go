@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+const pkcs12ContentType = "application/x-pkcs12"
+
+func init() {
+	registerView(&objectView[azsecrets.Secret]{
+		objectKind: secretKind,
+		nameSuffix: "",
+		renderer: func(ctx context.Context, secret azsecrets.Secret) ([]byte, error) {
+			return secretValue(secret), nil
+		},
+	})
+	registerView(&objectView[azsecrets.Secret]{
+		objectKind: secretKind,
+		nameSuffix: ".response",
+		renderer: func(ctx context.Context, secret azsecrets.Secret) ([]byte, error) {
+			return json.Marshal(secret)
+		},
+	})
+	// Provide the base64-decoded value as .pfx file
+	registerView(&objectView[azsecrets.Secret]{
+		objectKind: secretKind,
+		nameSuffix: ".pfx",
+		offeredFor: func(props objectProperties) bool {
+			return props.contentType == pkcs12ContentType
+		},
+		renderer: func(ctx context.Context, secret azsecrets.Secret) ([]byte, error) {
+			return ConvertEntry(base64PfxType, secretValue(secret)), nil
+		},
+	})
+}
+
+func secretValue(secret azsecrets.Secret) []byte {
+	if secret.Value == nil {
+		return nil
+	}
+	return []byte(*secret.Value)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type objectKind int
+
+const (
+	certificateKind objectKind = iota + 1
+	keyKind
+	secretKind
+)
+
+func (kind objectKind) String() string {
+	switch kind {
+	case certificateKind:
+		return "certificate"
+	case keyKind:
+		return "key"
+	case secretKind:
+		return "secret"
+	default:
+		return "unknown"
+	}
+}
+
+// objectProperties is what a directory listing tells us about a Key Vault
+// object without fetching it.
+type objectProperties struct {
+	name        string
+	contentType string
+	modTime     time.Time
+}
+
+// view is one virtual file representation of a Key Vault object, e.g. the
+// PEM encoding of a certificate. Every object listed in a directory gets one
+// file per view registered for its kind, named after the object plus the
+// view's suffix.
+type view interface {
+	// kind is the kind of object the view represents.
+	kind() objectKind
+	// suffix is appended to the object name to form the file name.
+	suffix() string
+	// offered decides from the listing metadata whether the file exists.
+	offered(props objectProperties) bool
+	// fetch retrieves the object the view is rendered from.
+	fetch(ctx context.Context, backend Backend, name string) (any, error)
+	// render produces the file contents from a fetched object.
+	render(ctx context.Context, object any) ([]byte, error)
+	// size determines the length of the file contents for a fetched object.
+	size(ctx context.Context, object any) (int64, error)
+}
+
+var registeredViews = map[objectKind][]view{}
+
+// registerView adds a file representation. Files are listed in the order
+// their views were registered.
+func registerView(v view) {
+	registeredViews[v.kind()] = append(registeredViews[v.kind()], v)
+}
+
+func viewsFor(kind objectKind) []view {
+	return registeredViews[kind]
+}
+
+// viewFor returns the view of kind with the given suffix, or nil.
+func viewFor(kind objectKind, suffix string) view {
+	for _, v := range registeredViews[kind] {
+		if v.suffix() == suffix {
+			return v
+		}
+	}
+	return nil
+}
+
+// objectView implements view for objects fetched as T using plain functions,
+// which covers every file representation that does not need any state.
+type objectView[T any] struct {
+	objectKind objectKind
+	nameSuffix string
+	// offeredFor may be nil if the file exists for every object.
+	offeredFor func(props objectProperties) bool
+	// fetcher may be nil to fetch the current version of the object. It is
+	// set for views rendered from another object, like the secret backing
+	// a certificate.
+	fetcher  func(ctx context.Context, backend Backend, name string) (T, error)
+	renderer func(ctx context.Context, object T) ([]byte, error)
+}
+
+var (
+	_ view = (*objectView[any])(nil)
+)
+
+func (v *objectView[T]) kind() objectKind {
+	return v.objectKind
+}
+
+func (v *objectView[T]) suffix() string {
+	return v.nameSuffix
+}
+
+func (v *objectView[T]) offered(props objectProperties) bool {
+	return v.offeredFor == nil || v.offeredFor(props)
+}
+
+func (v *objectView[T]) fetch(ctx context.Context, backend Backend, name string) (any, error) {
+	if v.fetcher != nil {
+		return v.fetcher(ctx, backend, name)
+	}
+	return fetchObject(ctx, backend, v.objectKind, name)
+}
+
+func (v *objectView[T]) render(ctx context.Context, object any) ([]byte, error) {
+	typed, ok := object.(T)
+	if !ok {
+		return nil, errors.Errorf("%s view %q cannot render %T", v.objectKind, v.nameSuffix, object)
+	}
+	return v.renderer(ctx, typed)
+}
+
+func (v *objectView[T]) size(ctx context.Context, object any) (int64, error) {
+	data, err := v.render(ctx, object)
+	if err != nil {
+		return -1, err
+	}
+	return int64(len(data)), nil
+}
+
+// fetchObject retrieves the current version of an object.
+func fetchObject(ctx context.Context, backend Backend, kind objectKind, name string) (any, error) {
+	switch kind {
+	case certificateKind:
+		certificate, err := backend.GetCertificate(ctx, name)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get certificate")
+		}
+		return certificate, nil
+	case keyKind:
+		key, err := backend.GetKey(ctx, name)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get key")
+		}
+		return key, nil
+	case secretKind:
+		secret, err := backend.GetSecret(ctx, name)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get secret")
+		}
+		return secret, nil
+	default:
+		return nil, errors.Errorf("unknown object kind %d", kind)
+	}
+}
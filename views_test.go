@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"syscall"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_viewsRegistered(t *testing.T) {
+	suffixes := func(kind objectKind) []string {
+		var result []string
+		for _, v := range viewsFor(kind) {
+			result = append(result, v.suffix())
+		}
+		return result
+	}
+	assert.Equal(t, []string{"", ".pem", ".chain.pem", ".key.pem", ".fullchain.pem", ".bundle.pem", ".p12", ".jks", ".info", ".info.json", ".sha256", ".sha1", ".response"}, suffixes(certificateKind))
+	assert.Equal(t, []string{"", ".pem", ".pub.pem", ".pub.ssh", ".jwk.json", ".response"}, suffixes(keyKind))
+	assert.Equal(t, []string{"", ".response", ".pfx"}, suffixes(secretKind))
+}
+
+func Test_pfxViewOffered(t *testing.T) {
+	v := viewFor(secretKind, ".pfx")
+	assert.NotNil(t, v)
+	assert.True(t, v.offered(objectProperties{name: "a", contentType: pkcs12ContentType}))
+	assert.False(t, v.offered(objectProperties{name: "a", contentType: "text/plain"}))
+
+	value := base64.StdEncoding.EncodeToString([]byte("pfx data"))
+	data, err := v.render(context.Background(), azsecrets.Secret{Value: &value})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pfx data"), data)
+}
+
+func Test_certificatePemView(t *testing.T) {
+	v := viewFor(certificateKind, ".pem")
+	data, err := v.render(context.Background(), azcertificates.Certificate{CER: []byte{1, 2, 3}})
+	assert.NoError(t, err)
+	block, _ := pem.Decode(data)
+	assert.Equal(t, "CERTIFICATE", block.Type)
+	assert.Equal(t, []byte{1, 2, 3}, block.Bytes)
+
+	size, err := v.size(context.Background(), azcertificates.Certificate{CER: []byte{1, 2, 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+
+	_, err = v.render(context.Background(), azsecrets.Secret{})
+	assert.Error(t, err, "views must reject objects of another kind")
+}
+
+func Test_keyPublicViews(t *testing.T) {
+	ctx := context.Background()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	for _, private := range []crypto.Signer{rsaKey, ecKey} {
+		jwk, err := jsonWebKeyFromPrivate(private)
+		require.NoError(t, err)
+		kid := azkeys.ID("https://example.vault.azure.net/keys/signing/0123")
+		jwk.KID = &kid
+		key := azkeys.KeyBundle{Key: publicJSONWebKey(jwk)}
+
+		_, err = viewFor(keyKind, "").render(ctx, key)
+		assert.Equal(t, syscall.ENODATA, errnoFor(err), "Key Vault does not return private keys")
+
+		data, err := viewFor(keyKind, ".pub.pem").render(ctx, key)
+		require.NoError(t, err)
+		block, _ := pem.Decode(data)
+		require.NotNil(t, block)
+		assert.Equal(t, "PUBLIC KEY", block.Type)
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		require.NoError(t, err)
+		assert.True(t, private.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(public))
+
+		pemData, err := viewFor(keyKind, ".pem").render(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, data, pemData, ".pem no longer claims to be a private key")
+
+		data, err = viewFor(keyKind, ".pub.ssh").render(ctx, key)
+		require.NoError(t, err)
+		sshKey, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+		require.NoError(t, err)
+		assert.Equal(t, "signing", comment)
+		expected, err := ssh.NewPublicKey(private.Public())
+		require.NoError(t, err)
+		assert.Equal(t, expected.Marshal(), sshKey.Marshal())
+
+		data, err = viewFor(keyKind, ".jwk.json").render(ctx, key)
+		require.NoError(t, err)
+		var parsed map[string]any
+		require.NoError(t, json.Unmarshal(data, &parsed))
+		assert.Equal(t, string(*jwk.Kty), parsed["kty"])
+		assert.NotContains(t, parsed, "d")
+	}
+
+	oct := azkeys.KeyTypeOct
+	symmetric := azkeys.KeyBundle{Key: &azkeys.JSONWebKey{Kty: &oct, K: []byte("k")}}
+	_, err = viewFor(keyKind, ".pub.pem").render(ctx, symmetric)
+	assert.Equal(t, syscall.ENODATA, errnoFor(err), "symmetric keys have no public key")
+	data, err := viewFor(keyKind, "").render(ctx, symmetric)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("k"), data)
+}